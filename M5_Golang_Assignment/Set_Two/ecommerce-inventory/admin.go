@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"ecommerce-inventory/config"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"ecommerce-inventory/service"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const createAdminUsage = "usage: ecommerce-inventory create-admin USERNAME [flags] < password-file"
+
+// runCreateAdmin runs the create-admin command, which registers an admin with
+// the password on the first line of stdin. Users registering through the API
+// are viewers, as are the users of a database upgraded from before roles, so
+// this is how an install gets its first admin.
+func runCreateAdmin(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New(createAdminUsage)
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("no password on stdin, " + createAdminUsage)
+	}
+
+	db, err := config.InitializeDatabase(cfg.Database.Path, cfg.Database.AutoMigrate)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user := &model.User{Username: args[0], Password: password}
+	if err := service.NewUserService(repository.NewUserRepository(db)).RegisterAdmin(user); err != nil {
+		return err
+	}
+	fmt.Printf("Created admin %s with ID %d\n", user.Username, user.ID)
+	return nil
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// KeyConfig describes a token signing key. HS256 keys take a shared Secret,
+// RS256 and EdDSA keys a PEM encoded private key in PrivateKeyFile.
+type KeyConfig struct {
+	ID             string `json:"id"`
+	Algorithm      string `json:"algorithm"`
+	Secret         string `json:"secret,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+}
+
+type key struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+// KeyManager signs tokens with the active key and verifies tokens signed with
+// any configured key, so that during a rotation tokens signed with the old
+// key stay valid until they expire. Tokens carry the ID of their key in the
+// kid header.
+type KeyManager struct {
+	keys       map[string]*key
+	active     *key
+	algorithms []string
+}
+
+// NewKeyManager loads the configured keys, signing with the one identified by activeID
+func NewKeyManager(configs []KeyConfig, activeID string) (*KeyManager, error) {
+	manager := &KeyManager{keys: make(map[string]*key)}
+	for _, config := range configs {
+		if config.ID == "" {
+			return nil, errors.New("signing key without an id")
+		}
+		if _, ok := manager.keys[config.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key id %q", config.ID)
+		}
+		k, err := loadKey(config)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", config.ID, err)
+		}
+		manager.keys[config.ID] = k
+		if !slices.Contains(manager.algorithms, config.Algorithm) {
+			manager.algorithms = append(manager.algorithms, config.Algorithm)
+		}
+	}
+
+	manager.active = manager.keys[activeID]
+	if manager.active == nil {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeID)
+	}
+	return manager, nil
+}
+
+func loadKey(config KeyConfig) (*key, error) {
+	switch config.Algorithm {
+	case HS256:
+		if config.Secret == "" {
+			return nil, errors.New("HS256 key needs a secret")
+		}
+		secret := []byte(config.Secret)
+		return &key{id: config.ID, method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+	case RS256:
+		private, err := loadPrivateKey(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := private.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("RS256 key must be an RSA private key")
+		}
+		return &key{id: config.ID, method: jwt.SigningMethodRS256, signKey: rsaKey, verifyKey: &rsaKey.PublicKey}, nil
+	case EdDSA:
+		private, err := loadPrivateKey(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("EdDSA key must be an Ed25519 private key")
+		}
+		return &key{id: config.ID, method: jwt.SigningMethodEdDSA, signKey: edKey, verifyKey: edKey.Public()}, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", config.Algorithm)
+}
+
+// loadPrivateKey reads a PKCS #8 or PKCS #1 PEM private key
+func loadPrivateKey(path string) (crypto.PrivateKey, error) {
+	if path == "" {
+		return nil, errors.New("private key file required")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key file is not PEM encoded")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// Sign signs claims with the active key
+func (manager *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(manager.active.method, claims)
+	token.Header["kid"] = manager.active.id
+	return token.SignedString(manager.active.signKey)
+}
+
+// Parse verifies a token against the key named by its kid header and reads
+// its claims. Tokens without a kid, issued before keys were named, are
+// checked against the active key.
+func (manager *KeyManager) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, manager.keyFor, jwt.WithValidMethods(manager.algorithms))
+}
+
+func (manager *KeyManager) keyFor(token *jwt.Token) (any, error) {
+	k := manager.active
+	if kid, ok := token.Header["kid"]; ok {
+		id, _ := kid.(string)
+		if k = manager.keys[id]; k == nil {
+			return nil, fmt.Errorf("unknown signing key %q", id)
+		}
+	}
+	// A key only verifies tokens of its own algorithm, so that for instance
+	// an RSA public key can never be used as an HMAC secret
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return k.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys other services can verify tokens with. HS256
+// secrets are symmetric and never published.
+func (manager *KeyManager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range manager.keys {
+		switch public := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType: "RSA", KeyID: k.id, Use: "sig", Algorithm: RS256,
+				N: base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType: "OKP", KeyID: k.id, Use: "sig", Algorithm: EdDSA,
+				Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
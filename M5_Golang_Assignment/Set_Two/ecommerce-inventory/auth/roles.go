@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles a user can have
+const (
+	RoleAdmin   = "admin"
+	RoleManager = "manager"
+	RoleViewer  = "viewer"
+)
+
+// Permissions required by the API routes
+const (
+	PermProductRead      = "product:read"
+	PermProductWrite     = "product:write"
+	PermProductDelete    = "product:delete"
+	PermProductRestore   = "product:restore"
+	PermStockRead        = "stock:read"
+	PermStockAdjust      = "stock:adjust"
+	PermCategoryRead     = "category:read"
+	PermCategoryWrite    = "category:write"
+	PermCategoryDelete   = "category:delete"
+	PermReservationRead  = "reservation:read"
+	PermReservationWrite = "reservation:write"
+	PermUserManage       = "user:manage"
+)
+
+// permissions is the permission matrix: the permissions granted to each role
+var permissions = map[string][]string{
+	RoleAdmin: {
+		PermProductRead, PermProductWrite, PermProductDelete, PermProductRestore,
+		PermStockRead, PermStockAdjust,
+		PermCategoryRead, PermCategoryWrite, PermCategoryDelete,
+		PermReservationRead, PermReservationWrite,
+		PermUserManage,
+	},
+	RoleManager: {
+		PermProductRead, PermProductWrite,
+		PermStockRead, PermStockAdjust,
+		PermCategoryRead, PermCategoryWrite,
+		PermReservationRead, PermReservationWrite,
+	},
+	RoleViewer: {
+		PermProductRead,
+		PermStockRead,
+		PermCategoryRead,
+		PermReservationRead,
+	},
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := permissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants permission
+func HasPermission(role, permission string) bool {
+	return slices.Contains(permissions[role], permission)
+}
+
+// Claims are the claims of an access token
+type Claims struct {
+	jwt.RegisteredClaims
+	Role string `json:"role,omitempty"`
+}
@@ -0,0 +1,36 @@
+package auth
+
+import "testing"
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{RoleAdmin, PermProductDelete, true},
+		{RoleAdmin, PermProductRestore, true},
+		{RoleAdmin, PermUserManage, true},
+		{RoleManager, PermProductWrite, true},
+		{RoleManager, PermStockAdjust, true},
+		{RoleManager, PermProductDelete, false},
+		{RoleManager, PermProductRestore, false},
+		{RoleManager, PermCategoryDelete, false},
+		{RoleManager, PermUserManage, false},
+		{RoleViewer, PermProductRead, true},
+		{RoleViewer, PermReservationRead, true},
+		{RoleViewer, PermProductWrite, false},
+		{RoleViewer, PermProductDelete, false},
+		{RoleViewer, PermStockAdjust, false},
+		{RoleViewer, PermReservationWrite, false},
+		{RoleViewer, PermUserManage, false},
+		{"", PermProductRead, false},
+		{"superuser", PermProductRead, false},
+		{RoleAdmin, "product:launch", false},
+	}
+	for _, test := range tests {
+		if got := HasPermission(test.role, test.permission); got != test.want {
+			t.Errorf("HasPermission(%q, %q) = %v, want %v", test.role, test.permission, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// Package catalog reads and writes product catalogs as CSV, JSON and NDJSON,
+// for bulk imports and exports.
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"ecommerce-inventory/model"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// ContentTypes maps each format to its media type
+var ContentTypes = map[string]string{
+	FormatCSV:    "text/csv",
+	FormatJSON:   "application/json",
+	FormatNDJSON: "application/x-ndjson",
+}
+
+// FormatOf returns the format of a media type. application/ndjson is
+// accepted as well as application/x-ndjson.
+func FormatOf(mediaType string) (string, bool) {
+	if mediaType == "application/ndjson" {
+		return FormatNDJSON, true
+	}
+	for format, contentType := range ContentTypes {
+		if contentType == mediaType {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// Columns are the CSV columns and JSON fields of a product in a catalog. The
+// ID, available stock and version are left out of imports, which always
+// create new products.
+var Columns = []string{"name", "description", "price", "stock", "category_id"}
+
+// exportColumns are the columns of an export. deleted_at is only set in
+// exports including deleted products.
+var exportColumns = append([]string{"id"}, append(Columns, "available", "version", "deleted_at")...)
+
+// ErrTooManyRows is returned when an import has more rows than allowed
+var ErrTooManyRows = errors.New("too many rows")
+
+// Record is one row of an imported catalog. Err is set when the row could
+// not be decoded, in which case Product is incomplete.
+type Record struct {
+	// Line is the line of the row in the upload, counting the CSV header
+	Line    int
+	Product model.Product
+	Err     error
+}
+
+// Read decodes every row of a catalog in the CSV or NDJSON format, failing
+// with ErrTooManyRows past maxRows. Rows that cannot be decoded are returned
+// with their error; only a malformed CSV header or failing to read r fails
+// the whole catalog.
+func Read(r io.Reader, format string, maxRows int) ([]Record, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r, maxRows)
+	case FormatNDJSON:
+		return readNDJSON(r, maxRows)
+	}
+	return nil, fmt.Errorf("cannot import the %s format", format)
+}
+
+func readCSV(r io.Reader, maxRows int) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the CSV header: %w", err)
+	}
+	positions := map[string]int{}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		if !slices.Contains(Columns, column) {
+			return nil, fmt.Errorf("unknown CSV column %q, expected some of %s", column, strings.Join(Columns, ", "))
+		}
+		if _, ok := positions[column]; ok {
+			return nil, fmt.Errorf("duplicate CSV column %q", column)
+		}
+		positions[column] = i
+	}
+
+	var records []Record
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if len(records) == maxRows {
+			return nil, fmt.Errorf("%w, at most %d are allowed", ErrTooManyRows, maxRows)
+		}
+		// A malformed row is skipped, but failing to read the upload at all
+		// fails the whole catalog
+		var record Record
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			record = Record{Line: parseErr.StartLine, Err: err}
+		case err != nil:
+			return nil, err
+		default:
+			record.Line, _ = reader.FieldPos(0)
+			if len(fields) != len(header) {
+				record.Err = fmt.Errorf("expected %d fields, got %d", len(header), len(fields))
+			} else {
+				record.Err = decodeCSVRow(&record.Product, fields, positions)
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+// decodeCSVRow sets the fields of product from a CSV row
+func decodeCSVRow(product *model.Product, fields []string, positions map[string]int) error {
+	value := func(column string) (string, bool) {
+		i, ok := positions[column]
+		if !ok {
+			return "", false
+		}
+		return strings.TrimSpace(fields[i]), true
+	}
+
+	product.Name, _ = value("name")
+	product.Description, _ = value("description")
+	var err error
+	if price, ok := value("price"); ok && price != "" {
+		if product.Price, err = strconv.ParseFloat(price, 64); err != nil {
+			return fmt.Errorf("invalid price %q", price)
+		}
+	}
+	if stock, ok := value("stock"); ok && stock != "" {
+		if product.Stock, err = strconv.Atoi(stock); err != nil {
+			return fmt.Errorf("invalid stock %q", stock)
+		}
+	}
+	if categoryID, ok := value("category_id"); ok && categoryID != "" {
+		if product.CategoryID, err = strconv.Atoi(categoryID); err != nil {
+			return fmt.Errorf("invalid category_id %q", categoryID)
+		}
+	}
+	return nil
+}
+
+// catalogProduct is a product as it appears in an NDJSON import
+type catalogProduct struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	Stock       int     `json:"stock"`
+	CategoryID  int     `json:"category_id"`
+}
+
+func readNDJSON(r io.Reader, maxRows int) ([]Record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var records []Record
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(records) == maxRows {
+			return nil, fmt.Errorf("%w, at most %d are allowed", ErrTooManyRows, maxRows)
+		}
+
+		record := Record{Line: line}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		var product catalogProduct
+		if err := decoder.Decode(&product); err != nil {
+			record.Err = describeJSONError(err)
+		} else if decoder.More() {
+			record.Err = errors.New("expected a single JSON object")
+		}
+		record.Product = model.Product{
+			Name:        product.Name,
+			Description: product.Description,
+			Price:       product.Price,
+			Stock:       product.Stock,
+			CategoryID:  product.CategoryID,
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// describeJSONError rewords type errors, which name Go types, in terms of
+// the JSON field, and drops the package prefix of the others
+func describeJSONError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	switch typeErr.Type.Kind() {
+	case reflect.String:
+		return fmt.Errorf("invalid %s: must be a string", typeErr.Field)
+	case reflect.Int:
+		return fmt.Errorf("invalid %s: must be an integer", typeErr.Field)
+	}
+	return fmt.Errorf("invalid %s: must be a number", typeErr.Field)
+}
+
+// Writer encodes the products of an export one at a time
+type Writer interface {
+	Write(product model.Product) error
+	// Close writes whatever the format needs after the last product and
+	// flushes. It does not close the underlying writer.
+	Close() error
+}
+
+// NewWriter creates a Writer for the CSV, JSON or NDJSON format. A JSON
+// export is a single array, written as the products come.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		writer := &csvWriter{csv: csv.NewWriter(w)}
+		return writer, writer.csv.Write(exportColumns)
+	case FormatJSON:
+		return &jsonWriter{w: bufio.NewWriter(w), array: true}, nil
+	case FormatNDJSON:
+		return &jsonWriter{w: bufio.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("cannot export the %s format", format)
+}
+
+type csvWriter struct {
+	csv *csv.Writer
+}
+
+func (writer *csvWriter) Write(product model.Product) error {
+	var deletedAt string
+	if product.DeletedAt != nil {
+		deletedAt = product.DeletedAt.UTC().Format(time.RFC3339)
+	}
+	return writer.csv.Write([]string{
+		strconv.Itoa(product.ID),
+		product.Name,
+		product.Description,
+		strconv.FormatFloat(product.Price, 'f', -1, 64),
+		strconv.Itoa(product.Stock),
+		strconv.Itoa(product.CategoryID),
+		strconv.Itoa(product.Available),
+		strconv.Itoa(product.Version),
+		deletedAt,
+	})
+}
+
+func (writer *csvWriter) Close() error {
+	writer.csv.Flush()
+	return writer.csv.Error()
+}
+
+// jsonWriter writes products as a JSON array or as one JSON object per line
+type jsonWriter struct {
+	w       *bufio.Writer
+	array   bool
+	started bool
+}
+
+func (writer *jsonWriter) Write(product model.Product) error {
+	data, err := json.Marshal(product)
+	if err != nil {
+		return err
+	}
+	if writer.array {
+		if writer.started {
+			writer.w.WriteString(",\n")
+		} else {
+			writer.w.WriteString("[\n")
+		}
+	}
+	writer.started = true
+	writer.w.Write(data)
+	if !writer.array {
+		writer.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (writer *jsonWriter) Close() error {
+	if writer.array {
+		if writer.started {
+			writer.w.WriteString("\n]\n")
+		} else {
+			writer.w.WriteString("[]\n")
+		}
+	}
+	return writer.w.Flush()
+}
@@ -0,0 +1,90 @@
+package catalog_test
+
+import (
+	"ecommerce-inventory/catalog"
+	"ecommerce-inventory/model"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadCSV(t *testing.T) {
+	upload := "\ufeffName, Price ,stock\n" +
+		"Lamp,20,5\n" +
+		"a\"b,1,1\n" +
+		"Chair,45\n" +
+		"Desk,120,2,extra\n" +
+		"Mug,cheap,1\n" +
+		"\"Rug\",60,3\n"
+	records, err := catalog.Read(strings.NewReader(upload), catalog.FormatCSV, 100)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	want := []struct {
+		line    int
+		product model.Product
+		err     string
+	}{
+		{2, model.Product{Name: "Lamp", Price: 20, Stock: 5}, ""},
+		{3, model.Product{}, `bare " in non-quoted-field`},
+		{4, model.Product{}, "expected 3 fields, got 2"},
+		{5, model.Product{}, "expected 3 fields, got 4"},
+		{6, model.Product{}, `invalid price "cheap"`},
+		{7, model.Product{Name: "Rug", Price: 60, Stock: 3}, ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Read returned %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i, record := range records {
+		if record.Line != want[i].line {
+			t.Errorf("record %d is on line %d, want %d", i, record.Line, want[i].line)
+		}
+		if want[i].err != "" {
+			if record.Err == nil || !strings.Contains(record.Err.Error(), want[i].err) {
+				t.Errorf("line %d: got error %v, want %q", record.Line, record.Err, want[i].err)
+			}
+			continue
+		}
+		if record.Err != nil || record.Product != want[i].product {
+			t.Errorf("line %d: got %+v, %v, want %+v", record.Line, record.Product, record.Err, want[i].product)
+		}
+	}
+}
+
+func TestReadCSVHeader(t *testing.T) {
+	for _, header := range []string{"name,colour\n", "name,price,Name\n", "name,\"price\n"} {
+		if _, err := catalog.Read(strings.NewReader(header+"Lamp,20\n"), catalog.FormatCSV, 100); err == nil {
+			t.Errorf("Read accepted the header %q", header)
+		}
+	}
+	records, err := catalog.Read(strings.NewReader(""), catalog.FormatCSV, 100)
+	if err != nil || len(records) != 0 {
+		t.Errorf("Read of an empty upload = %v, %v, want no records", records, err)
+	}
+}
+
+func TestReadMaxRows(t *testing.T) {
+	csv := "name,price\nLamp,20\nChair,45\n"
+	ndjson := `{"name": "Lamp", "price": 20}` + "\n" + `{"name": "Chair", "price": 45}` + "\n"
+	for format, upload := range map[string]string{catalog.FormatCSV: csv, catalog.FormatNDJSON: ndjson} {
+		if records, err := catalog.Read(strings.NewReader(upload), format, 2); err != nil || len(records) != 2 {
+			t.Errorf("%s: Read of 2 rows with a cap of 2 = %d records, %v", format, len(records), err)
+		}
+		if _, err := catalog.Read(strings.NewReader(upload), format, 1); !errors.Is(err, catalog.ErrTooManyRows) {
+			t.Errorf("%s: Read of 2 rows with a cap of 1: got %v, want ErrTooManyRows", format, err)
+		}
+	}
+}
+
+func TestReadFailure(t *testing.T) {
+	failure := errors.New("connection reset")
+	upload := iotest.TimeoutReader(strings.NewReader("name,price\nLamp,20\n"))
+	if _, err := catalog.Read(upload, catalog.FormatCSV, 100); !errors.Is(err, iotest.ErrTimeout) {
+		t.Errorf("Read of a failing upload: got %v, want %v", err, iotest.ErrTimeout)
+	}
+	if _, err := catalog.Read(iotest.ErrReader(failure), catalog.FormatNDJSON, 100); !errors.Is(err, failure) {
+		t.Errorf("Read of a failing upload: got %v, want %v", err, failure)
+	}
+}
@@ -0,0 +1,364 @@
+package config
+
+import (
+	"ecommerce-inventory/tracing"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the server. It is loaded by Load from, in
+// increasing order of precedence, the defaults, an optional YAML file, the
+// environment and the command line flags.
+type Config struct {
+	Server       ServerConfig       `yaml:"server"`
+	Database     DatabaseConfig     `yaml:"database"`
+	Auth         AuthConfig         `yaml:"auth"`
+	Reservations ReservationsConfig `yaml:"reservations"`
+	Products     ProductsConfig     `yaml:"products"`
+	Pagination   PaginationConfig   `yaml:"pagination"`
+	RateLimits   RateLimitsConfig   `yaml:"rate_limits"`
+	Log          LogConfig          `yaml:"log"`
+	Tracing      TracingConfig      `yaml:"tracing"`
+}
+
+// ServerConfig sets the timeouts of the HTTP server, zero meaning none.
+// ShutdownTimeout bounds how long in-flight requests may take to drain.
+// Metrics are served on their own listener at MetricsAddr, which should only
+// be reachable from inside the network, or not at all when it is empty.
+type ServerConfig struct {
+	Addr              string        `yaml:"addr"`
+	MetricsAddr       string        `yaml:"metrics_addr"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+}
+
+type DatabaseConfig struct {
+	Path string `yaml:"path"`
+	// AutoMigrate applies pending migrations at startup
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+type AuthConfig struct {
+	// JWTSecret signs tokens with HS256 unless KeysFile is set, see LoadSigningKeys
+	JWTSecret       string        `yaml:"jwt_secret"`
+	KeysFile        string        `yaml:"keys_file"`
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+type ReservationsConfig struct {
+	TTL            time.Duration `yaml:"ttl"`
+	ExpiryInterval time.Duration `yaml:"expiry_interval"`
+}
+
+// ProductsConfig sets how long deleted products can be restored before the
+// purge, which runs every PurgeInterval, deletes them for good
+type ProductsConfig struct {
+	Retention     time.Duration `yaml:"retention"`
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+type PaginationConfig struct {
+	DefaultLimit int `yaml:"default_limit"`
+	MaxLimit     int `yaml:"max_limit"`
+}
+
+type RateLimitsConfig struct {
+	Anonymous  RateLimitConfig `yaml:"anonymous"`
+	Authorized RateLimitConfig `yaml:"authorized"`
+}
+
+// RateLimitConfig allows Requests per Per, with bursts of up to Burst requests
+type RateLimitConfig struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst"`
+}
+
+type LogConfig struct {
+	Level          string  `yaml:"level"`
+	Format         string  `yaml:"format"`
+	BodySampleRate float64 `yaml:"body_sample_rate"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of the OTLP/HTTP collector
+	Endpoint    string  `yaml:"endpoint"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// Default returns the settings used when nothing else is configured
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			MetricsAddr:       "localhost:9090",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   15 * time.Second,
+		},
+		Database: DatabaseConfig{Path: "./ecommerce.db", AutoMigrate: true},
+		Auth: AuthConfig{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+			CleanupInterval: time.Hour,
+		},
+		Reservations: ReservationsConfig{TTL: 15 * time.Minute, ExpiryInterval: 30 * time.Second},
+		Products:     ProductsConfig{Retention: 30 * 24 * time.Hour, PurgeInterval: time.Hour},
+		Pagination:   PaginationConfig{DefaultLimit: 10, MaxLimit: 100},
+		RateLimits: RateLimitsConfig{
+			Anonymous:  RateLimitConfig{Requests: 10, Per: time.Minute, Burst: 5},
+			Authorized: RateLimitConfig{Requests: 600, Per: time.Minute, Burst: 100},
+		},
+		Log:     LogConfig{Level: "info", Format: "json"},
+		Tracing: TracingConfig{Exporter: "none", SampleRatio: 1},
+	}
+}
+
+// setting is one configuration value that can also be set through an
+// environment variable and a flag
+type setting struct {
+	flag, env, usage string
+	value            flag.Value
+}
+
+func (cfg *Config) settings() []setting {
+	return []setting{
+		{"addr", "SERVER_ADDR", "address to listen on", (*stringValue)(&cfg.Server.Addr)},
+		{"metrics-addr", "SERVER_METRICS_ADDR", "address to serve /metrics on, empty to not serve metrics", (*stringValue)(&cfg.Server.MetricsAddr)},
+		{"read-header-timeout", "SERVER_READ_HEADER_TIMEOUT", "time allowed to read request headers", (*durationValue)(&cfg.Server.ReadHeaderTimeout)},
+		{"read-timeout", "SERVER_READ_TIMEOUT", "time allowed to read a whole request", (*durationValue)(&cfg.Server.ReadTimeout)},
+		{"write-timeout", "SERVER_WRITE_TIMEOUT", "time allowed to write a response", (*durationValue)(&cfg.Server.WriteTimeout)},
+		{"idle-timeout", "SERVER_IDLE_TIMEOUT", "how long idle keep-alive connections stay open", (*durationValue)(&cfg.Server.IdleTimeout)},
+		{"shutdown-timeout", "SERVER_SHUTDOWN_TIMEOUT", "time allowed for in-flight requests to finish on shutdown", (*durationValue)(&cfg.Server.ShutdownTimeout)},
+		{"db", "DB_PATH", "path of the SQLite database", (*stringValue)(&cfg.Database.Path)},
+		{"auto-migrate", "DB_AUTO_MIGRATE", "apply pending migrations at startup", (*boolValue)(&cfg.Database.AutoMigrate)},
+		{"jwt-secret", "JWT_SECRET", "secret signing HS256 tokens when no keys file is set", (*stringValue)(&cfg.Auth.JWTSecret)},
+		{"jwt-keys-file", "JWT_KEYS_FILE", "JSON file listing the token signing keys", (*stringValue)(&cfg.Auth.KeysFile)},
+		{"access-token-ttl", "ACCESS_TOKEN_TTL", "lifetime of access tokens", (*durationValue)(&cfg.Auth.AccessTokenTTL)},
+		{"refresh-token-ttl", "REFRESH_TOKEN_TTL", "lifetime of refresh tokens", (*durationValue)(&cfg.Auth.RefreshTokenTTL)},
+		{"token-cleanup-interval", "TOKEN_CLEANUP_INTERVAL", "how often expired tokens are deleted", (*durationValue)(&cfg.Auth.CleanupInterval)},
+		{"reservation-ttl", "RESERVATION_TTL", "how long reservations hold stock", (*durationValue)(&cfg.Reservations.TTL)},
+		{"reservation-expiry-interval", "RESERVATION_EXPIRY_INTERVAL", "how often expired reservations are released", (*durationValue)(&cfg.Reservations.ExpiryInterval)},
+		{"product-retention", "PRODUCT_RETENTION", "how long deleted products can be restored before they are purged", (*durationValue)(&cfg.Products.Retention)},
+		{"product-purge-interval", "PRODUCT_PURGE_INTERVAL", "how often deleted products past their retention are purged", (*durationValue)(&cfg.Products.PurgeInterval)},
+		{"default-page-size", "DEFAULT_PAGE_SIZE", "page size of listings without a limit", (*intValue)(&cfg.Pagination.DefaultLimit)},
+		{"max-page-size", "MAX_PAGE_SIZE", "largest page size of listings", (*intValue)(&cfg.Pagination.MaxLimit)},
+		{"anonymous-rate-limit", "ANONYMOUS_RATE_LIMIT", "requests per period allowed per client IP on anonymous routes", (*intValue)(&cfg.RateLimits.Anonymous.Requests)},
+		{"anonymous-rate-limit-period", "ANONYMOUS_RATE_LIMIT_PERIOD", "period of the anonymous rate limit", (*durationValue)(&cfg.RateLimits.Anonymous.Per)},
+		{"anonymous-rate-limit-burst", "ANONYMOUS_RATE_LIMIT_BURST", "burst allowed by the anonymous rate limit", (*intValue)(&cfg.RateLimits.Anonymous.Burst)},
+		{"authorized-rate-limit", "AUTHORIZED_RATE_LIMIT", "requests per period allowed per user on authenticated routes", (*intValue)(&cfg.RateLimits.Authorized.Requests)},
+		{"authorized-rate-limit-period", "AUTHORIZED_RATE_LIMIT_PERIOD", "period of the authorized rate limit", (*durationValue)(&cfg.RateLimits.Authorized.Per)},
+		{"authorized-rate-limit-burst", "AUTHORIZED_RATE_LIMIT_BURST", "burst allowed by the authorized rate limit", (*intValue)(&cfg.RateLimits.Authorized.Burst)},
+		{"log-level", "LOG_LEVEL", "debug, info, warn or error", (*stringValue)(&cfg.Log.Level)},
+		{"log-format", "LOG_FORMAT", "json or text", (*stringValue)(&cfg.Log.Format)},
+		{"log-body-sample-rate", "LOG_BODY_SAMPLE_RATE", "fraction of requests whose redacted bodies are logged", (*floatValue)(&cfg.Log.BodySampleRate)},
+		{"trace-exporter", "TRACE_EXPORTER", "none, stdout or otlp", (*stringValue)(&cfg.Tracing.Exporter)},
+		{"trace-endpoint", "TRACE_ENDPOINT", "URL of the OTLP/HTTP collector receiving spans", (*stringValue)(&cfg.Tracing.Endpoint)},
+		{"trace-sample-ratio", "TRACE_SAMPLE_RATIO", "fraction of new traces that are recorded", (*floatValue)(&cfg.Tracing.SampleRatio)},
+	}
+}
+
+// Options are the command line options that are not settings
+type Options struct {
+	// PrintConfig asks to print the effective configuration and exit
+	PrintConfig bool
+}
+
+// Load reads the configuration for the command line args, which do not
+// include the program name. The config file is named by --config or
+// CONFIG_FILE. The result is validated.
+func Load(args []string) (Config, Options, error) {
+	cfg := Default()
+	var options Options
+	configFile := os.Getenv("CONFIG_FILE")
+
+	flags := flag.NewFlagSet("ecommerce-inventory", flag.ContinueOnError)
+	flags.StringVar(&configFile, "config", configFile, "YAML config file, also read from CONFIG_FILE")
+	flags.BoolVar(&options.PrintConfig, "print-config", false, "print the effective configuration with secrets masked and exit")
+	settings := cfg.settings()
+	for _, s := range settings {
+		flags.Var(s.value, s.flag, s.usage+" ("+s.env+")")
+	}
+
+	// Flags win over the file and the environment, so they are parsed first
+	// to find the file and applied again once the others are loaded
+	if err := flags.Parse(args); err != nil {
+		return cfg, options, err
+	}
+	if flags.NArg() > 0 {
+		return cfg, options, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	setFlags := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	cfg = Default()
+	if configFile != "" {
+		if err := cfg.loadFile(configFile); err != nil {
+			return cfg, options, err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.value.Set(value); err != nil {
+				return cfg, options, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := setFlags[s.flag]; ok {
+			s.value.Set(value)
+		}
+	}
+
+	return cfg, options, cfg.Validate()
+}
+
+// loadFile overrides the settings present in a YAML file
+func (cfg *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Server.Addr != "", "server.addr must not be empty")
+	check(cfg.Server.MetricsAddr != cfg.Server.Addr, "server.metrics_addr must differ from server.addr")
+	check(cfg.Server.ReadHeaderTimeout >= 0 && cfg.Server.ReadTimeout >= 0 && cfg.Server.WriteTimeout >= 0 && cfg.Server.IdleTimeout >= 0,
+		"server timeouts must not be negative")
+	check(cfg.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(cfg.Database.Path != "", "database.path must not be empty")
+	// Tokens carry the role of their user, so a guessable key would let
+	// anyone sign themselves an admin token
+	check(cfg.Auth.JWTSecret != "" || cfg.Auth.KeysFile != "", "auth.jwt_secret or auth.keys_file must be set")
+	check(cfg.Auth.AccessTokenTTL > 0, "auth.access_token_ttl must be positive")
+	check(cfg.Auth.RefreshTokenTTL > cfg.Auth.AccessTokenTTL, "auth.refresh_token_ttl must be longer than auth.access_token_ttl")
+	check(cfg.Auth.CleanupInterval > 0, "auth.cleanup_interval must be positive")
+	check(cfg.Reservations.TTL > 0, "reservations.ttl must be positive")
+	check(cfg.Reservations.ExpiryInterval > 0, "reservations.expiry_interval must be positive")
+	check(cfg.Products.Retention > 0, "products.retention must be positive")
+	check(cfg.Products.PurgeInterval > 0, "products.purge_interval must be positive")
+	check(cfg.Pagination.MaxLimit >= 1, "pagination.max_limit must be at least 1")
+	check(cfg.Pagination.DefaultLimit >= 1 && cfg.Pagination.DefaultLimit <= cfg.Pagination.MaxLimit,
+		"pagination.default_limit must be between 1 and pagination.max_limit")
+	checkRateLimit := func(name string, limit RateLimitConfig) {
+		check(limit.Requests >= 1 && limit.Per > 0 && limit.Burst >= 1,
+			"rate_limits.%s needs requests, per and burst to be positive", name)
+	}
+	checkRateLimit("anonymous", cfg.RateLimits.Anonymous)
+	checkRateLimit("authorized", cfg.RateLimits.Authorized)
+	_, err := cfg.Log.Options()
+	check(err == nil, "log.level must be debug, info, warn or error")
+	check(cfg.Log.Format == "json" || cfg.Log.Format == "text", "log.format must be json or text")
+	check(cfg.Log.BodySampleRate >= 0 && cfg.Log.BodySampleRate <= 1, "log.body_sample_rate must be between 0 and 1")
+	check(tracing.ValidExporter(cfg.Tracing.Exporter), "tracing.exporter must be none, stdout or otlp")
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Print writes the configuration as YAML with the secrets masked
+func (cfg Config) Print(w io.Writer) error {
+	if cfg.Auth.JWTSecret != "" {
+		cfg.Auth.JWTSecret = "********"
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// flag.Value implementations pointing at the fields of a Config
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type boolValue bool
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type floatValue float64
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 15m", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
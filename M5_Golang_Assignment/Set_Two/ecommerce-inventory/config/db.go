@@ -2,41 +2,101 @@ package config
 
 import (
 	"database/sql"
+	"ecommerce-inventory/migrations"
+	"fmt"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func InitializeDatabase() (*sql.DB, error) {
-	// Open database connection
-	db, err := sql.Open("sqlite3", "./ecommerce.db")
+// OpenDatabase opens the SQLite database at path without touching its schema
+func OpenDatabase(path string) (*sql.DB, error) {
+	// Transactions take the write lock up front so concurrent
+	// read-modify-write transactions queue instead of failing. In WAL mode
+	// reads, such as a long export, do not block writes either.
+	return sql.Open("sqlite3", path+"?_busy_timeout=5000&_txlock=immediate&_journal_mode=WAL")
+}
+
+// InitializeDatabase opens the database and brings its schema up to date.
+// With autoMigrate off, pending migrations are an error and have to be
+// applied with the migrate command. A schema newer than this binary is
+// always an error.
+func InitializeDatabase(path string, autoMigrate bool) (*sql.DB, error) {
+	db, err := OpenDatabase(path)
 	if err != nil {
 		log.Fatal("Error opening database: ", err)
 		return nil, err
 	}
 
-	// Create users table
-	if _, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE,
-		password TEXT
-	);`); err != nil {
-		log.Fatal("Error creating users table: ", err)
+	pending, err := migrations.Pending(db)
+	if err != nil {
+		log.Fatal("Error checking database migrations: ", err)
+		return nil, err
+	}
+	if len(pending) > 0 && !autoMigrate {
+		err = fmt.Errorf("database has %d pending migrations, run the migrate up command", len(pending))
+		log.Fatal(err)
+		return nil, err
+	}
+	applied, err := migrations.Up(db)
+	for _, migration := range applied {
+		log.Printf("Applied migration %d %s", migration.Version, migration.Name)
+	}
+	if err != nil {
+		log.Fatal("Error migrating database: ", err)
 		return nil, err
 	}
 
-	// Create products table
-	if _, err = db.Exec(`CREATE TABLE IF NOT EXISTS products (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT,
-		description TEXT,
-		price REAL,
-		stock INTEGER,
-		category_id INTEGER
-	);`); err != nil {
-		log.Fatal("Error creating products table: ", err)
+	if err = initializeSearchIndex(db); err != nil {
+		log.Fatal("Error creating product search index: ", err)
 		return nil, err
 	}
 
 	return db, nil
 }
+
+// initializeSearchIndex keeps the products_fts full-text index in sync with
+// the products table through triggers. FTS5 is only compiled into go-sqlite3
+// with the sqlite_fts5 build tag, which the Makefile sets. Without it the
+// triggers are dropped, so that writes to products keep working, and product
+// search falls back to LIKE matching.
+func initializeSearchIndex(db *sql.DB) error {
+	var enabled bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		log.Println("WARNING: SQLite was built without FTS5, product search falls back to LIKE matching; build with make build or go build -tags sqlite_fts5")
+		_, err := db.Exec(`DROP TRIGGER IF EXISTS products_fts_insert;
+			DROP TRIGGER IF EXISTS products_fts_delete;
+			DROP TRIGGER IF EXISTS products_fts_update;`)
+		return err
+	}
+
+	// The index misses every write made while the triggers were absent, so
+	// it is rebuilt whenever they have to be created
+	var synced bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'products_fts_insert')`).
+		Scan(&synced); err != nil {
+		return err
+	}
+	if synced {
+		return nil
+	}
+
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS products_fts USING fts5(
+		name, description, content = 'products', content_rowid = 'id'
+	);
+	CREATE TRIGGER products_fts_insert AFTER INSERT ON products BEGIN
+		INSERT INTO products_fts (rowid, name, description) VALUES (new.id, new.name, new.description);
+	END;
+	CREATE TRIGGER products_fts_delete AFTER DELETE ON products BEGIN
+		INSERT INTO products_fts (products_fts, rowid, name, description) VALUES ('delete', old.id, old.name, old.description);
+	END;
+	CREATE TRIGGER products_fts_update AFTER UPDATE OF name, description ON products BEGIN
+		INSERT INTO products_fts (products_fts, rowid, name, description) VALUES ('delete', old.id, old.name, old.description);
+		INSERT INTO products_fts (rowid, name, description) VALUES (new.id, new.name, new.description);
+	END;
+	INSERT INTO products_fts (products_fts) VALUES ('rebuild');`)
+	return err
+}
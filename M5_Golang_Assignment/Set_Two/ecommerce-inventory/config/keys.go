@@ -0,0 +1,38 @@
+package config
+
+import (
+	"ecommerce-inventory/auth"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// LoadSigningKeys reads the token signing keys and the ID of the active key.
+// The keys file is a JSON file of the form
+//
+//	{"active": "2024-06", "keys": [{"id": "2024-06", "algorithm": "EdDSA", "private_key_file": "keys/2024-06.pem"}]}
+//
+// Without it a single HS256 key with ID "default" is made from the JWT secret,
+// which must then be set.
+func LoadSigningKeys(cfg AuthConfig) ([]auth.KeyConfig, string, error) {
+	if path := cfg.KeysFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		var file struct {
+			Active string           `json:"active"`
+			Keys   []auth.KeyConfig `json:"keys"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, "", fmt.Errorf("parsing %s: %w", path, err)
+		}
+		return file.Keys, file.Active, nil
+	}
+
+	if cfg.JWTSecret == "" {
+		return nil, "", errors.New("no signing key is configured, set auth.jwt_secret or auth.keys_file")
+	}
+	return []auth.KeyConfig{{ID: "default", Algorithm: auth.HS256, Secret: cfg.JWTSecret}}, "default", nil
+}
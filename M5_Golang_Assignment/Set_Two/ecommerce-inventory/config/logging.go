@@ -0,0 +1,10 @@
+package config
+
+import "ecommerce-inventory/logging"
+
+// Options converts the log settings into logger options
+func (cfg LogConfig) Options() (logging.Options, error) {
+	options := logging.Options{JSON: cfg.Format != "text", BodySampleRate: cfg.BodySampleRate}
+	err := options.Level.UnmarshalText([]byte(cfg.Level))
+	return options, err
+}
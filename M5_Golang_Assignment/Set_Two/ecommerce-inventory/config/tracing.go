@@ -0,0 +1,8 @@
+package config
+
+import "ecommerce-inventory/tracing"
+
+// Options converts the tracing settings into tracing options
+func (cfg TracingConfig) Options() tracing.Options {
+	return tracing.Options{Exporter: cfg.Exporter, Endpoint: cfg.Endpoint, SampleRatio: cfg.SampleRatio}
+}
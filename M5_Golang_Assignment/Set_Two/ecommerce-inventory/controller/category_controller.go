@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"ecommerce-inventory/middleware"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CategoryController struct {
+	CategoryService *service.CategoryService
+}
+
+func NewCategoryController(service *service.CategoryService) *CategoryController {
+	return &CategoryController{CategoryService: service}
+}
+
+// Add a category
+func (controller *CategoryController) AddCategory(c *gin.Context) {
+	var category model.Category
+	if err := c.ShouldBindJSON(&category); err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := controller.CategoryService.AddCategory(&category); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, category)
+}
+
+// Get a category by ID
+func (controller *CategoryController) GetCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	category, err := controller.CategoryService.GetCategoryByID(id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+// Get all categories
+func (controller *CategoryController) GetAllCategories(c *gin.Context) {
+	categories, err := controller.CategoryService.GetAllCategories()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, categories)
+}
+
+// Update a category
+func (controller *CategoryController) UpdateCategory(c *gin.Context) {
+	var category model.Category
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	if err := c.ShouldBindJSON(&category); err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	category.ID = id
+
+	if err := controller.CategoryService.UpdateCategory(&category); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category updated successfully"})
+}
+
+// Delete a category by ID
+func (controller *CategoryController) DeleteCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	if err := controller.CategoryService.DeleteCategory(id); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"ecommerce-inventory/middleware"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"ecommerce-inventory/service"
+	"ecommerce-inventory/validation"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondError writes the problem response for a failed operation, with the
+// status matching the kind of the error. Unexpected errors are logged rather
+// than shown to the client.
+func respondError(c *gin.Context, err error) {
+	var fields validation.Errors
+	var stockErr *repository.StockError
+	var importErr *service.ImportError
+	switch {
+	case errors.As(err, &fields):
+		problem := middleware.NewProblem(c, http.StatusUnprocessableEntity, "The request has invalid fields")
+		problem["fields"] = fields
+		middleware.RenderProblem(c, problem)
+	case errors.As(err, &importErr):
+		// Reports every row of the rejected import
+		problem := middleware.NewProblem(c, http.StatusUnprocessableEntity, importErr.Error())
+		problem["report"] = importErr.Report
+		middleware.RenderProblem(c, problem)
+	case errors.As(err, &stockErr):
+		// Lists the lines of the stock change that could not be applied
+		problem := middleware.NewProblem(c, http.StatusConflict, stockErr.Error())
+		problem["lines"] = stockErr.Lines
+		middleware.RenderProblem(c, problem)
+	case errors.Is(err, model.ErrNotFound):
+		middleware.WriteProblem(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, model.ErrConflict):
+		middleware.WriteProblem(c, http.StatusConflict, err.Error())
+	case errors.Is(err, model.ErrPreconditionFailed):
+		middleware.WriteProblem(c, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, model.ErrValidation):
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, model.ErrUnauthorized):
+		middleware.WriteProblem(c, http.StatusUnauthorized, err.Error())
+	default:
+		c.Error(err)
+		middleware.WriteProblem(c, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// bindJSON decodes the request body into v. Values of the wrong JSON type are
+// reported as invalid fields, other malformed bodies as bad requests.
+func bindJSON(c *gin.Context, v any) bool {
+	err := c.ShouldBindJSON(v)
+	if err == nil {
+		return true
+	}
+	if fields := validation.TypeError(err); fields != nil {
+		respondError(c, fields)
+	} else {
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"ecommerce-inventory/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		body  string
+		want  int
+		field string
+	}{
+		{`{"name": "Lamp", "price": 10, "stock": 1}`, http.StatusOK, ""},
+		{`{"name": "Lamp", "price": "10"}`, http.StatusUnprocessableEntity, "price"},
+		{`{"name": 7}`, http.StatusUnprocessableEntity, "name"},
+		{`{"name": "Lamp"`, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		router := gin.New()
+		router.POST("/", func(c *gin.Context) {
+			var product model.Product
+			if bindJSON(c, &product) {
+				c.Status(http.StatusOK)
+			}
+		})
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body)))
+		if recorder.Code != test.want {
+			t.Errorf("%s: status %d, want %d", test.body, recorder.Code, test.want)
+			continue
+		}
+		if test.field == "" {
+			continue
+		}
+		var problem struct {
+			Fields []struct {
+				Field string `json:"field"`
+				Code  string `json:"code"`
+			} `json:"fields"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("%s: %v", test.body, err)
+		}
+		if len(problem.Fields) != 1 || problem.Fields[0].Field != test.field || problem.Fields[0].Code != "invalid_type" {
+			t.Errorf("%s: fields %+v, want an invalid_type error on %s", test.body, problem.Fields, test.field)
+		}
+	}
+}
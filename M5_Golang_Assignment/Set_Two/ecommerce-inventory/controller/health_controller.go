@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"ecommerce-inventory/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthController struct {
+	HealthService *service.HealthService
+}
+
+func NewHealthController(service *service.HealthService) *HealthController {
+	return &HealthController{HealthService: service}
+}
+
+// Report that the process is up, without touching its dependencies
+func (controller *HealthController) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Report whether the server can take traffic: the database answers and its
+// schema is up to date. Failing checks give a 503 with the reason.
+func (controller *HealthController) Readiness(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+	record := func(name string, err error) {
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	record("database", controller.HealthService.CheckDatabase(c.Request.Context()))
+	if ready {
+		record("migrations", controller.HealthService.CheckMigrations())
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
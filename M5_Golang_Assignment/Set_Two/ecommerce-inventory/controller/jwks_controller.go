@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"ecommerce-inventory/auth"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSController struct {
+	Keys *auth.KeyManager
+}
+
+func NewJWKSController(keys *auth.KeyManager) *JWKSController {
+	return &JWKSController{Keys: keys}
+}
+
+// Publish the public token verification keys
+func (controller *JWKSController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, controller.Keys.JWKS())
+}
@@ -1,32 +1,46 @@
 package controller
 
 import (
+	"ecommerce-inventory/auth"
+	"ecommerce-inventory/catalog"
+	"ecommerce-inventory/middleware"
 	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
 	"ecommerce-inventory/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/gin-gonic/gin"
 )
 
 type ProductController struct {
 	ProductService *service.ProductService
+	// DefaultLimit is the page size of listings without a limit parameter
+	DefaultLimit int
 }
 
-func NewProductController(service *service.ProductService) *ProductController {
-	return &ProductController{ProductService: service}
+func NewProductController(service *service.ProductService, defaultLimit int) *ProductController {
+	return &ProductController{ProductService: service, DefaultLimit: defaultLimit}
 }
 
 // Add a product
 func (controller *ProductController) AddProduct(c *gin.Context) {
 	var product model.Product
-	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &product) {
 		return
 	}
 
-	if err := controller.ProductService.AddProduct(&product); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := controller.ProductService.AddProduct(c.Request.Context(), &product, c.GetString(middleware.UserKey)); err != nil {
+		respondError(c, err)
 		return
 	}
 
@@ -37,68 +51,553 @@ func (controller *ProductController) AddProduct(c *gin.Context) {
 func (controller *ProductController) GetProduct(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid product ID")
 		return
 	}
 
-	product, err := controller.ProductService.GetProductByID(id)
+	product, err := controller.ProductService.GetProductByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
+	c.Header("ETag", productETag(product.Version))
 	c.JSON(http.StatusOK, product)
 }
 
-// Update a product
+// Update a product. The If-Match header must hold the ETag of the version
+// the update is based on.
 func (controller *ProductController) UpdateProduct(c *gin.Context) {
 	var product model.Product
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid product ID")
 		return
 	}
 
-	product.ID = id
-	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	version, ok := controller.expectedVersion(c, id)
+	if !ok {
+		return
+	}
+	if !bindJSON(c, &product) {
 		return
 	}
+	product.ID = id
+	product.Version = version
 
-	if err := controller.ProductService.UpdateProduct(&product); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := controller.ProductService.UpdateProduct(c.Request.Context(), &product, c.GetString(middleware.UserKey)); err != nil {
+		respondError(c, err)
 		return
 	}
 
+	c.Header("ETag", productETag(product.Version))
 	c.JSON(http.StatusOK, gin.H{"message": "Product updated successfully"})
 }
 
+// Patch media types accepted by PatchProduct
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// Partially update a product with a JSON Merge Patch (RFC 7396) or a JSON
+// Patch (RFC 6902), told apart by the Content-Type. Like UpdateProduct it
+// requires the ETag of the patched version in If-Match.
+func (controller *ProductController) PatchProduct(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != mergePatchType && mediaType != jsonPatchType {
+		c.Header("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		middleware.WriteProblem(c, http.StatusUnsupportedMediaType,
+			"Invalid content type, expected "+mergePatchType+" or "+jsonPatchType)
+		return
+	}
+	version, ok := controller.expectedVersion(c, id)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var patch service.ProductPatch
+	if mediaType == mergePatchType {
+		if !json.Valid(body) {
+			middleware.WriteProblem(c, http.StatusBadRequest, "The merge patch is not valid JSON")
+			return
+		}
+		patch = func(document []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(document, body)
+		}
+	} else {
+		operations, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			middleware.WriteProblem(c, http.StatusBadRequest, "The JSON patch is invalid: "+err.Error())
+			return
+		}
+		patch = operations.Apply
+	}
+
+	product, err := controller.ProductService.PatchProduct(c.Request.Context(), id, version, patch, c.GetString(middleware.UserKey))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Header("ETag", productETag(product.Version))
+	c.JSON(http.StatusOK, product)
+}
+
+// productETag is the entity tag of a version of a product
+func productETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// expectedVersion reads the version of product id that a conditional update
+// is based on from its If-Match header. A missing header is answered with 428
+// and tags that cannot match, such as weak ones, with 412. "*" matches
+// whatever the current version is, making the update unconditional. Of a list
+// of tags, the one of the current version is picked.
+func (controller *ProductController) expectedVersion(c *gin.Context, id int) (int, bool) {
+	ifMatch := strings.TrimSpace(strings.Join(c.Request.Header.Values("If-Match"), ","))
+	if ifMatch == "" {
+		middleware.WriteProblem(c, http.StatusPreconditionRequired, "Updating a product requires an If-Match header with its ETag")
+		return 0, false
+	}
+	if ifMatch == "*" {
+		return repository.AnyVersion, true
+	}
+
+	versions := matchedVersions(ifMatch)
+	if len(versions) > 1 {
+		product, err := controller.ProductService.GetProductByID(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return 0, false
+		}
+		if slices.Contains(versions, product.Version) {
+			return product.Version, true
+		}
+		versions = nil
+	}
+	if len(versions) == 0 {
+		middleware.WriteProblem(c, http.StatusPreconditionFailed, "If-Match does not match the current ETag of the product")
+		return 0, false
+	}
+	return versions[0], true
+}
+
+// matchedVersions reads the product versions named by a list of entity tags
+// (RFC 9110, section 8.8.3). If-Match compares tags strongly, so weak tags
+// and tags that are not product versions are skipped, and so is the rest of
+// a list that is malformed.
+func matchedVersions(tags string) []int {
+	var versions []int
+	for {
+		tags = strings.TrimLeft(tags, " \t,")
+		if tags == "" {
+			return versions
+		}
+		weak := strings.HasPrefix(tags, "W/")
+		tags = strings.TrimPrefix(tags, "W/")
+		opaque, found := strings.CutPrefix(tags, `"`)
+		if !found {
+			return versions
+		}
+		opaque, tags, found = strings.Cut(opaque, `"`)
+		if !found {
+			return versions
+		}
+		if version, err := strconv.Atoi(opaque); err == nil && version >= 1 && !weak {
+			versions = append(versions, version)
+		}
+	}
+}
+
 // Delete a product by ID
 func (controller *ProductController) DeleteProduct(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid product ID")
 		return
 	}
 
-	if err := controller.ProductService.DeleteProduct(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := controller.ProductService.DeleteProduct(c.Request.Context(), id); err != nil {
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
 }
 
-// Get all products with pagination
+// Restore a deleted product that has not been purged yet
+func (controller *ProductController) RestoreProduct(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	product, err := controller.ProductService.RestoreProduct(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Header("ETag", productETag(product.Version))
+	c.JSON(http.StatusOK, product)
+}
+
+// Get products with filtering, sorting and pagination
 func (controller *ProductController) GetAllProducts(c *gin.Context) {
+	filter, err := controller.parseProductFilter(c)
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	controller.listProducts(c, filter)
+}
+
+// Get the products of a category, including subcategories when include_descendants=true
+func (controller *ProductController) GetProductsByCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	filter, err := controller.parseProductFilter(c)
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.CategoryID = &id
+	controller.listProducts(c, filter)
+}
+
+// listProducts writes one page of the products matching filter. A cursor
+// query parameter, even an empty one, switches from page/limit paging to
+// keyset paging.
+func (controller *ProductController) listProducts(c *gin.Context, filter model.ProductFilter) {
+	if !allowDeleted(c, filter) {
+		return
+	}
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		products, next, err := controller.ProductService.GetProductsAfter(c.Request.Context(), filter, cursor)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		page := model.CursorPage[model.Product]{Items: products, Limit: filter.Limit, NextCursor: next}
+		if next != "" {
+			page.Links.Next = cursorLink(c, next)
+		}
+		c.JSON(http.StatusOK, page)
+		return
+	}
+
+	products, total, err := controller.ProductService.GetAllProducts(c.Request.Context(), filter)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	page := model.Page[model.Product]{Items: products, Total: total, Page: filter.Page, Limit: filter.Limit}
+	if filter.Page*filter.Limit < total {
+		page.Links.Next = pageLink(c, filter.Page+1)
+	}
+	if filter.Page > 1 {
+		page.Links.Prev = pageLink(c, filter.Page-1)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// parseProductFilter reads the filter, sort and pagination query parameters
+// of a product listing
+func (controller *ProductController) parseProductFilter(c *gin.Context) (model.ProductFilter, error) {
+	filter := model.ProductFilter{Query: strings.TrimSpace(c.Query("q"))}
+
+	var err error
+	if filter.Page, err = strconv.Atoi(c.DefaultQuery("page", "1")); err != nil {
+		return filter, errors.New("invalid page")
+	}
+	if filter.Limit, err = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(controller.DefaultLimit))); err != nil {
+		return filter, errors.New("invalid limit")
+	}
+	if value := c.Query("min_price"); value != "" {
+		minPrice, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return filter, errors.New("invalid min_price")
+		}
+		filter.MinPrice = &minPrice
+	}
+	if value := c.Query("max_price"); value != "" {
+		maxPrice, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return filter, errors.New("invalid max_price")
+		}
+		filter.MaxPrice = &maxPrice
+	}
+	if value := c.Query("category_id"); value != "" {
+		categoryID, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, errors.New("invalid category_id")
+		}
+		filter.CategoryID = &categoryID
+	}
+	if value := c.Query("include_descendants"); value != "" {
+		if filter.IncludeDescendants, err = strconv.ParseBool(value); err != nil {
+			return filter, errors.New("invalid include_descendants")
+		}
+	}
+	if value := c.Query("include_deleted"); value != "" {
+		if filter.IncludeDeleted, err = strconv.ParseBool(value); err != nil {
+			return filter, errors.New("invalid include_deleted")
+		}
+	}
+	if value := c.Query("in_stock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errors.New("invalid in_stock")
+		}
+		filter.InStock = &inStock
+	}
+
+	// sort=price,-stock sorts by price ascending, then by stock descending
+	for _, field := range strings.Split(c.Query("sort"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key := model.SortKey{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		filter.Sort = append(filter.Sort, key)
+	}
+	return filter, nil
+}
+
+// allowDeleted rejects listings including deleted products unless the user
+// may restore them
+func allowDeleted(c *gin.Context, filter model.ProductFilter) bool {
+	if !filter.IncludeDeleted {
+		return true
+	}
+	if claims := middleware.CurrentClaims(c); claims == nil || !auth.HasPermission(claims.Role, auth.PermProductRestore) {
+		middleware.WriteProblem(c, http.StatusForbidden, "Missing permission "+auth.PermProductRestore)
+		return false
+	}
+	return true
+}
+
+// cursorLink is the URL of the current request pointing at another cursor
+func cursorLink(c *gin.Context, cursor string) string {
+	query := c.Request.URL.Query()
+	query.Set("cursor", cursor)
+	return c.Request.URL.Path + "?" + query.Encode()
+}
+
+// pageLink is the URL of the current request pointing at another page
+func pageLink(c *gin.Context, page int) string {
+	query := c.Request.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	return c.Request.URL.Path + "?" + query.Encode()
+}
+
+// maxImportSize is the largest upload ImportProducts reads
+const maxImportSize = 32 << 20
+
+// maxExportDuration is how long ExportProducts may take to write an export,
+// in place of the server write timeout, which large exports could outlast
+const maxExportDuration = 10 * time.Minute
+
+// Import products from a CSV or NDJSON upload, reporting the outcome of every
+// row. With mode=atomic, the default, nothing is imported unless every row is
+// valid; mode=best_effort imports the valid rows and skips the others.
+// dry_run=true only validates the rows.
+func (controller *ProductController) ImportProducts(c *gin.Context) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	format, ok := catalog.FormatOf(mediaType)
+	if !ok || format == catalog.FormatJSON {
+		middleware.WriteProblem(c, http.StatusUnsupportedMediaType,
+			"Invalid content type, expected "+catalog.ContentTypes[catalog.FormatCSV]+" or "+catalog.ContentTypes[catalog.FormatNDJSON])
+		return
+	}
+
+	var options service.ImportOptions
+	switch c.DefaultQuery("mode", "atomic") {
+	case "atomic":
+		options.Atomic = true
+	case "best_effort":
+	default:
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid mode, expected atomic or best_effort")
+		return
+	}
+	if value := c.Query("dry_run"); value != "" {
+		var err error
+		if options.DryRun, err = strconv.ParseBool(value); err != nil {
+			middleware.WriteProblem(c, http.StatusBadRequest, "Invalid dry_run")
+			return
+		}
+	}
+
+	records, err := catalog.Read(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize), format, service.MaxImportRows)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		middleware.WriteProblem(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("The upload is larger than %d bytes", tooLarge.Limit))
+		return
+	case errors.Is(err, catalog.ErrTooManyRows):
+		middleware.WriteProblem(c, http.StatusRequestEntityTooLarge, "The upload has "+err.Error())
+		return
+	case err != nil:
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+
+	report, err := controller.ProductService.ImportProducts(c.Request.Context(), records, options, c.GetString(middleware.UserKey))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	status := http.StatusOK
+	if report.Created > 0 {
+		status = http.StatusCreated
+	}
+	c.JSON(status, report)
+}
+
+// Export the products matching the filters of a listing as CSV, the default,
+// JSON or NDJSON, ignoring paging. Products are written as they are read from
+// the store, so a large catalog is never held in memory.
+func (controller *ProductController) ExportProducts(c *gin.Context) {
+	format := c.DefaultQuery("format", catalog.FormatCSV)
+	contentType, ok := catalog.ContentTypes[format]
+	if !ok {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid format, expected csv, json or ndjson")
+		return
+	}
+	filter, err := controller.parseProductFilter(c)
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !allowDeleted(c, filter) {
+		return
+	}
+
+	// The response starts with the first product, so that a rejected filter
+	// can still be reported as a problem
+	var writer catalog.Writer
+	start := func() error {
+		http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(maxExportDuration))
+		c.Header("Content-Type", contentType+"; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="products.`+format+`"`)
+		c.Status(http.StatusOK)
+		w, err := catalog.NewWriter(c.Writer, format)
+		writer = w
+		return err
+	}
+	err = controller.ProductService.ExportProducts(c.Request.Context(), filter, func(product model.Product) error {
+		if writer == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return writer.Write(product)
+	})
+	if err == nil && writer == nil {
+		err = start()
+	}
+	if err != nil && writer == nil {
+		respondError(c, err)
+		return
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// Too late for a problem response, the client gets a truncated export
+		c.Error(err)
+	}
+}
+
+// Adjust stock for several products in a single all-or-nothing batch
+func (controller *ProductController) AdjustStock(c *gin.Context) {
+	var request struct {
+		Adjustments []model.StockAdjustment `json:"adjustments"`
+	}
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	levels, err := controller.ProductService.AdjustStock(c.Request.Context(), request.Adjustments, c.GetString(middleware.UserKey))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock adjusted successfully", "stock": levels})
+}
+
+// Get the stock movements of a product with pagination and an optional date range
+func (controller *ProductController) GetStockMovements(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(controller.DefaultLimit)))
+	if page < 1 || limit < 1 {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid page or limit")
+		return
+	}
+	filter := model.StockMovementFilter{Page: page, Limit: limit}
+
+	if value := c.Query("from"); value != "" {
+		from, _, err := parseDateParam(value)
+		if err != nil {
+			middleware.WriteProblem(c, http.StatusBadRequest, "Invalid from date")
+			return
+		}
+		filter.From = &from
+	}
+	if value := c.Query("to"); value != "" {
+		to, dateOnly, err := parseDateParam(value)
+		if err != nil {
+			middleware.WriteProblem(c, http.StatusBadRequest, "Invalid to date")
+			return
+		}
+		// A bare date includes the whole day
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
+	}
 
-	products, err := controller.ProductService.GetAllProducts(page, limit)
+	movements, err := controller.ProductService.GetStockMovements(c.Request.Context(), id, filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, products)
+	c.JSON(http.StatusOK, gin.H{"movements": movements, "page": page, "limit": limit})
+}
+
+// parseDateParam accepts an RFC 3339 timestamp or a bare YYYY-MM-DD date
+func parseDateParam(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
 }
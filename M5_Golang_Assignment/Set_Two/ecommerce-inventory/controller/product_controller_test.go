@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMatchedVersions(t *testing.T) {
+	tests := []struct {
+		tags string
+		want []int
+	}{
+		{`"3"`, []int{3}},
+		{`"3", "5"`, []int{3, 5}},
+		{` "3" ,,"5",`, []int{3, 5}},
+		{`W/"3", "5"`, []int{5}},
+		{`"a,3", "4"`, []int{4}},
+		{`"0", "-1", "x"`, nil},
+		{`3`, nil},
+		{`"3", "4`, []int{3}},
+	}
+	for _, test := range tests {
+		if got := matchedVersions(test.tags); !slices.Equal(got, test.want) {
+			t.Errorf("matchedVersions(%q) = %v, want %v", test.tags, got, test.want)
+		}
+	}
+}
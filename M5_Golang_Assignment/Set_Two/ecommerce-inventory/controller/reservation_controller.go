@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"ecommerce-inventory/middleware"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReservationController struct {
+	ReservationService *service.ReservationService
+}
+
+func NewReservationController(service *service.ReservationService) *ReservationController {
+	return &ReservationController{ReservationService: service}
+}
+
+// Create a reservation holding stock for a checkout
+func (controller *ReservationController) CreateReservation(c *gin.Context) {
+	var request struct {
+		Items []model.ReservationItem `json:"items"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reservation, err := controller.ReservationService.CreateReservation(c.Request.Context(), request.Items)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, reservation)
+}
+
+// Get a reservation by ID
+func (controller *ReservationController) GetReservation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid reservation ID")
+		return
+	}
+
+	reservation, err := controller.ReservationService.GetReservationByID(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, reservation)
+}
+
+// Commit a reservation, deducting the held stock
+func (controller *ReservationController) CommitReservation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid reservation ID")
+		return
+	}
+
+	reservation, err := controller.ReservationService.CommitReservation(c.Request.Context(), id, c.GetString(middleware.UserKey))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, reservation)
+}
+
+// Release a reservation, returning the held stock
+func (controller *ReservationController) ReleaseReservation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid reservation ID")
+		return
+	}
+
+	if err := controller.ReservationService.ReleaseReservation(c.Request.Context(), id); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reservation released successfully"})
+}
@@ -1,37 +1,41 @@
 package controller
 
 import (
+	"ecommerce-inventory/middleware"
 	"ecommerce-inventory/model"
 	"ecommerce-inventory/service"
 	"net/http"
-	"time"
+	"strconv"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
 type UserController struct {
-	UserService *service.UserService
+	UserService  *service.UserService
+	TokenService *service.TokenService
 }
 
-func NewUserController(userService *service.UserService) *UserController {
-	return &UserController{UserService: userService}
+func NewUserController(userService *service.UserService, tokenService *service.TokenService) *UserController {
+	return &UserController{UserService: userService, TokenService: tokenService}
 }
 
 // Register a new user
 func (controller *UserController) Register(c *gin.Context) {
-	var user model.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if !bindJSON(c, &credentials) {
 		return
 	}
 
+	user := model.User{Username: credentials.Username, Password: credentials.Password}
 	if err := controller.UserService.RegisterUser(&user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User registered successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "User registered successfully", "user": user})
 }
 
 // Login and authenticate user
@@ -41,44 +45,106 @@ func (controller *UserController) Login(c *gin.Context) {
 		Password string `json:"password"`
 	}
 
-	if err := c.ShouldBindJSON(&credentials); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &credentials) {
 		return
 	}
 
 	user, err := controller.UserService.AuthenticateUser(credentials.Username, credentials.Password)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	token, err := generateJWT(user.Username)
+	tokens, err := controller.TokenService.IssueTokens(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating JWT token"})
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Authentication successful",
-		"token":   token,
+		"message":       "Authentication successful",
+		"token":         tokens.AccessToken,
+		"token_type":    tokens.TokenType,
+		"expires_in":    tokens.ExpiresIn,
+		"refresh_token": tokens.RefreshToken,
 	})
 }
 
-// Generate JWT token
-func generateJWT(username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// Exchange a refresh token for new tokens
+func (controller *UserController) Refresh(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+		middleware.WriteProblem(c, http.StatusBadRequest, "refresh_token required")
+		return
+	}
+
+	tokens, err := controller.TokenService.Refresh(request.RefreshToken)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Log out, revoking the access token of the request and the given refresh token
+func (controller *UserController) Logout(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := controller.TokenService.Logout(middleware.CurrentClaims(c), request.RefreshToken); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// Revoke every session of a user
+func (controller *UserController) RevokeSessions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
 
-	claims := &jwt.StandardClaims{
-		ExpiresAt: expirationTime.Unix(),
-		Issuer:    "ecommerce-inventory",
-		Subject:   username,
+	if err := controller.TokenService.RevokeUserSessions(id); err != nil {
+		respondError(c, err)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte("secretkey"))
+	c.JSON(http.StatusOK, gin.H{"message": "User sessions revoked successfully"})
+}
+
+// Change the role of a user
+func (controller *UserController) UpdateRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		return "", err
+		middleware.WriteProblem(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var request struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		middleware.WriteProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := controller.UserService.UpdateRole(id, request.Role); err != nil {
+		respondError(c, err)
+		return
 	}
 
-	return tokenString, nil
+	c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully"})
 }
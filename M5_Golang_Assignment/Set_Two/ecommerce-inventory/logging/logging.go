@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Options control the application logger
+type Options struct {
+	Level slog.Level
+	// JSON selects JSON output, otherwise logs are written as key=value text
+	JSON bool
+	// BodySampleRate is the fraction of requests, between 0 and 1, whose
+	// redacted request and response bodies are logged
+	BodySampleRate float64
+}
+
+// NewLogger creates a structured logger writing to w. Records logged with a
+// context that carries a request ID include it.
+func NewLogger(w io.Writer, options Options) *slog.Logger {
+	handlerOptions := &slog.HandlerOptions{Level: options.Level}
+	if options.JSON {
+		return slog.New(contextHandler{slog.NewJSONHandler(w, handlerOptions)})
+	}
+	return slog.New(contextHandler{slog.NewTextHandler(w, handlerOptions)})
+}
+
+// redactedFields are JSON fields whose values are never logged
+var redactedFields = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"secret":        true,
+	"authorization": true,
+}
+
+// RedactBody prepares a sampled request or response body for the log.
+// Values of sensitive JSON fields are replaced, and bodies that are not JSON
+// are left out since they cannot be redacted reliably.
+func RedactBody(body []byte, truncated bool) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if truncated {
+		return "[body too large to sample]"
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "[non-JSON body omitted]"
+	}
+	redacted, err := json.Marshal(redact(value))
+	if err != nil {
+		return "[body omitted]"
+	}
+	return string(redacted)
+}
+
+func redact(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if redactedFields[strings.ToLower(key)] {
+				value[key] = "[REDACTED]"
+			} else {
+				value[key] = redact(field)
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redact(item)
+		}
+	}
+	return value
+}
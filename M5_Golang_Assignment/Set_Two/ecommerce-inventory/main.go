@@ -1,50 +1,268 @@
 package main
 
 import (
+	"context"
+	"ecommerce-inventory/auth"
 	"ecommerce-inventory/config"
 	"ecommerce-inventory/controller"
+	"ecommerce-inventory/logging"
 	"ecommerce-inventory/middleware"
 	"ecommerce-inventory/repository"
 	"ecommerce-inventory/service"
+	"ecommerce-inventory/tracing"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	// "migrate up|down|status" manages the database schema and "create-admin"
+	// adds an admin instead of serving
+	args := os.Args[1:]
+	var command []string
+	if len(args) > 0 && (args[0] == "migrate" || args[0] == "create-admin") {
+		command, args = splitCommand(args)
+	}
+
+	// Load configuration
+	cfg, options, err := config.Load(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal(err)
+	}
+	if options.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if command != nil {
+		run := runMigrate
+		if command[0] == "create-admin" {
+			run = runCreateAdmin
+		}
+		if err := run(cfg, command[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Set up structured logging, the log package writes through it as well
+	logOptions, err := cfg.Log.Options()
+	if err != nil {
+		log.Fatal("Invalid logging configuration: ", err)
+	}
+	logger := logging.NewLogger(os.Stdout, logOptions)
+	slog.SetDefault(logger)
+	gin.DebugPrintFunc = func(format string, values ...any) {
+		logger.Debug(strings.TrimSpace(fmt.Sprintf(format, values...)))
+	}
+
+	// Set up tracing, spans are exported until shutdown
+	shutdownTracing, err := tracing.Setup(cfg.Tracing.Options())
+	if err != nil {
+		log.Fatal("Setting up tracing failed: ", err)
+	}
+
 	// Initialize database
-	db, err := config.InitializeDatabase()
+	db, err := config.InitializeDatabase(cfg.Database.Path, cfg.Database.AutoMigrate)
 	if err != nil {
 		log.Fatal("Database connection failed:", err)
 	}
 
+	// Load token signing keys
+	keyConfigs, activeKey, err := config.LoadSigningKeys(cfg.Auth)
+	if err != nil {
+		log.Fatal("Loading signing keys failed: ", err)
+	}
+	keys, err := auth.NewKeyManager(keyConfigs, activeKey)
+	if err != nil {
+		log.Fatal("Loading signing keys failed: ", err)
+	}
+
 	// Initialize components
+	categoryRepo := repository.NewCategoryRepository(db)
+	categoryService := service.NewCategoryService(categoryRepo)
+	categoryController := controller.NewCategoryController(categoryService)
+
 	productRepo := repository.NewProductRepository(db)
-	productService := service.NewProductService(productRepo)
-	productController := controller.NewProductController(productService)
+	productService := service.NewProductService(productRepo, categoryRepo, cfg.Pagination.MaxLimit)
+	productController := controller.NewProductController(productService, cfg.Pagination.DefaultLimit)
+
+	reservationRepo := repository.NewReservationRepository(db)
+	reservationService := service.NewReservationService(reservationRepo, cfg.Reservations.TTL)
+	reservationController := controller.NewReservationController(reservationService)
 
 	userRepo := repository.NewUserRepository(db)
 	userService := service.NewUserService(userRepo)
-	userController := controller.NewUserController(userService)
+	tokenRepo := repository.NewTokenRepository(db)
+	tokenService := service.NewTokenService(tokenRepo, userRepo, keys, cfg.Auth.AccessTokenTTL, cfg.Auth.RefreshTokenTTL)
+	userController := controller.NewUserController(userService, tokenService)
+	jwksController := controller.NewJWKSController(keys)
+	healthController := controller.NewHealthController(service.NewHealthService(db))
+
+	// Metrics of the runtime, the connection pool, HTTP requests and the inventory
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "ecommerce"))
+	if err := productService.RegisterMetrics(registry); err != nil {
+		log.Fatal("Registering metrics failed: ", err)
+	}
+
+	// Background workers run until shutdown
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	workers := []<-chan struct{}{
+		reservationService.StartExpiryWorker(workerCtx, cfg.Reservations.ExpiryInterval),
+		tokenService.StartCleanupWorker(workerCtx, cfg.Auth.CleanupInterval),
+		productService.StartPurgeWorker(workerCtx, cfg.Products.PurgeInterval, cfg.Products.Retention),
+	}
 
-	// Set up router
-	router := gin.Default()
-	router.Use(middleware.LoggingMiddleware())
+	// Set up router. Client IPs come from the connection rather than from
+	// X-Forwarded-For, which clients could forge to dodge rate limits.
+	router := gin.New()
+	router.SetTrustedProxies(nil)
+	router.Use(middleware.RequestIDMiddleware(),
+		middleware.TracingMiddleware(),
+		middleware.LoggingMiddleware(logger, logOptions.BodySampleRate),
+		middleware.MetricsMiddleware(registry),
+		middleware.RecoveryMiddleware(logger))
+	router.NoRoute(func(c *gin.Context) {
+		middleware.WriteProblem(c, http.StatusNotFound, "Not found")
+	})
+
+	// Rate limits per route group
+	rateLimits := middleware.NewMemoryRateLimitStore()
+	anonymousLimit := middleware.RateLimit(cfg.RateLimits.Anonymous)
+	authorizedLimit := middleware.RateLimit(cfg.RateLimits.Authorized)
+
+	router.GET("/.well-known/jwks.json", jwksController.JWKS)
+	router.GET("/healthz", healthController.Liveness)
+	router.GET("/readyz", healthController.Readiness)
 
 	// User routes
-	router.POST("/register", userController.Register)
-	router.POST("/login", userController.Login)
+	anonymous := router.Group("/", middleware.RateLimitMiddleware(rateLimits, "anonymous", anonymousLimit, middleware.RateLimitByIP))
+	{
+		anonymous.POST("/register", userController.Register)
+		anonymous.POST("/login", userController.Login)
+		anonymous.POST("/token/refresh", userController.Refresh)
+	}
 
 	// Product routes with authentication
-	authorized := router.Group("/", middleware.AuthMiddleware())
+	authorized := router.Group("/", middleware.AuthMiddleware(keys, tokenService),
+		middleware.RateLimitMiddleware(rateLimits, "authorized", authorizedLimit, middleware.RateLimitBySubject))
 	{
-		authorized.POST("/product", middleware.ValidationMiddleware(), productController.AddProduct)
-		authorized.GET("/product/:id", productController.GetProduct)
-		authorized.PUT("/product/:id", productController.UpdateProduct)
-		authorized.DELETE("/product/:id", productController.DeleteProduct)
-		authorized.GET("/products", productController.GetAllProducts)
+		authorized.POST("/logout", userController.Logout)
+		authorized.POST("/users/:id/revoke-sessions", middleware.RequirePermission(auth.PermUserManage), userController.RevokeSessions)
+		authorized.PUT("/users/:id/role", middleware.RequirePermission(auth.PermUserManage), userController.UpdateRole)
+
+		authorized.POST("/product", middleware.RequirePermission(auth.PermProductWrite), middleware.ValidationMiddleware(), productController.AddProduct)
+		authorized.GET("/product/:id", middleware.RequirePermission(auth.PermProductRead), productController.GetProduct)
+		authorized.PUT("/product/:id", middleware.RequirePermission(auth.PermProductWrite), productController.UpdateProduct)
+		authorized.PATCH("/product/:id", middleware.RequirePermission(auth.PermProductWrite), productController.PatchProduct)
+		authorized.DELETE("/product/:id", middleware.RequirePermission(auth.PermProductDelete), productController.DeleteProduct)
+		authorized.POST("/product/:id/restore", middleware.RequirePermission(auth.PermProductRestore), productController.RestoreProduct)
+		authorized.GET("/products", middleware.RequirePermission(auth.PermProductRead), productController.GetAllProducts)
+		authorized.POST("/products/import", middleware.RequirePermission(auth.PermProductWrite), productController.ImportProducts)
+		authorized.GET("/products/export", middleware.RequirePermission(auth.PermProductRead), productController.ExportProducts)
+		authorized.GET("/product/:id/movements", middleware.RequirePermission(auth.PermStockRead), productController.GetStockMovements)
+		authorized.POST("/stock/adjust", middleware.RequirePermission(auth.PermStockAdjust), middleware.ValidationMiddleware(), productController.AdjustStock)
+
+		authorized.POST("/categories", middleware.RequirePermission(auth.PermCategoryWrite), middleware.ValidationMiddleware(), categoryController.AddCategory)
+		authorized.GET("/categories", middleware.RequirePermission(auth.PermCategoryRead), categoryController.GetAllCategories)
+		authorized.GET("/categories/:id", middleware.RequirePermission(auth.PermCategoryRead), categoryController.GetCategory)
+		authorized.PUT("/categories/:id", middleware.RequirePermission(auth.PermCategoryWrite), categoryController.UpdateCategory)
+		authorized.DELETE("/categories/:id", middleware.RequirePermission(auth.PermCategoryDelete), categoryController.DeleteCategory)
+		authorized.GET("/categories/:id/products", middleware.RequirePermission(auth.PermProductRead), productController.GetProductsByCategory)
+
+		authorized.POST("/reservations", middleware.RequirePermission(auth.PermReservationWrite), middleware.ValidationMiddleware(), reservationController.CreateReservation)
+		authorized.GET("/reservations/:id", middleware.RequirePermission(auth.PermReservationRead), reservationController.GetReservation)
+		authorized.POST("/reservations/:id/commit", middleware.RequirePermission(auth.PermReservationWrite), reservationController.CommitReservation)
+		authorized.DELETE("/reservations/:id", middleware.RequirePermission(auth.PermReservationWrite), reservationController.ReleaseReservation)
 	}
 
 	// Start server
-	router.Run(":8080")
+	server := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	serverErr := make(chan error, 2)
+	go func() {
+		slog.Info("Listening", "addr", cfg.Server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Metrics tell about the business as well, so they are kept off the
+	// public listener
+	var metricsServer *http.Server
+	if cfg.Server.MetricsAddr != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		metricsServer = &http.Server{
+			Addr:              cfg.Server.MetricsAddr,
+			Handler:           metrics,
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			WriteTimeout:      cfg.Server.WriteTimeout,
+		}
+		go func() {
+			slog.Info("Serving metrics", "addr", cfg.Server.MetricsAddr)
+			serverErr <- metricsServer.ListenAndServe()
+		}()
+	}
+
+	// Run until SIGINT or SIGTERM, or until the server fails
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	var failed bool
+	select {
+	case err := <-serverErr:
+		slog.Error("Server failed", "error", err)
+		failed = true
+	case <-signals.Done():
+		stopSignals()
+		slog.Info("Shutting down", "timeout", cfg.Server.ShutdownTimeout.String())
+	}
+
+	// Drain in-flight requests, then stop the workers before closing the
+	// database they use
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Requests did not drain in time", "error", err)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	stopWorkers()
+	for _, done := range workers {
+		<-done
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Closing the database failed", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Flushing spans failed", "error", err)
+	}
+	slog.Info("Shutdown complete")
+	if failed {
+		os.Exit(1)
+	}
 }
@@ -1,44 +1,86 @@
 package middleware
 
 import (
-	"fmt"
+	"ecommerce-inventory/auth"
 	"net/http"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware validates the JWT token
-func AuthMiddleware() gin.HandlerFunc {
+// Gin context keys set by AuthMiddleware
+const (
+	UserKey   = "username" // subject of the token
+	ClaimsKey = "claims"   // *auth.Claims of the token
+)
+
+// RevocationChecker tells whether a valid token was revoked before it expired
+type RevocationChecker interface {
+	IsRevoked(claims *auth.Claims) (bool, error)
+}
+
+// AuthMiddleware validates the JWT token, rejects revoked tokens and stores
+// the claims of the token under ClaimsKey and its subject under UserKey
+func AuthMiddleware(keys *auth.KeyManager, revocations RevocationChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			WriteProblem(c, http.StatusUnauthorized, "Authorization header required")
 			c.Abort()
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token required"})
+			WriteProblem(c, http.StatusUnauthorized, "Bearer token required")
 			c.Abort()
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return []byte("secretkey"), nil
-		})
-
+		claims := &auth.Claims{}
+		token, err := keys.Parse(tokenString, claims)
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			WriteProblem(c, http.StatusUnauthorized, "Invalid or expired token")
+			c.Abort()
+			return
+		}
+
+		revoked, err := revocations.IsRevoked(claims)
+		if err != nil {
+			WriteProblem(c, http.StatusInternalServerError, "Error checking token revocation")
+			c.Abort()
+			return
+		}
+		if revoked {
+			WriteProblem(c, http.StatusUnauthorized, "Token has been revoked")
 			c.Abort()
 			return
 		}
 
+		c.Set(ClaimsKey, claims)
+		c.Set(UserKey, claims.Subject)
+		c.Next()
+	}
+}
+
+// CurrentClaims returns the claims stored by AuthMiddleware, or nil on
+// routes that do not require authentication
+func CurrentClaims(c *gin.Context) *auth.Claims {
+	claims, _ := c.Get(ClaimsKey)
+	current, _ := claims.(*auth.Claims)
+	return current
+}
+
+// RequirePermission rejects requests whose token role lacks permission. It
+// must run after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := CurrentClaims(c)
+		if claims == nil || !auth.HasPermission(claims.Role, permission) {
+			WriteProblem(c, http.StatusForbidden, "Missing permission "+permission)
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"ecommerce-inventory/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		claims     *auth.Claims
+		permission string
+		want       int
+	}{
+		{"admin deletes", &auth.Claims{Role: auth.RoleAdmin}, auth.PermProductDelete, http.StatusOK},
+		{"admin manages users", &auth.Claims{Role: auth.RoleAdmin}, auth.PermUserManage, http.StatusOK},
+		{"manager writes", &auth.Claims{Role: auth.RoleManager}, auth.PermProductWrite, http.StatusOK},
+		{"manager manages users", &auth.Claims{Role: auth.RoleManager}, auth.PermUserManage, http.StatusForbidden},
+		{"viewer reads", &auth.Claims{Role: auth.RoleViewer}, auth.PermProductRead, http.StatusOK},
+		{"viewer deletes", &auth.Claims{Role: auth.RoleViewer}, auth.PermProductDelete, http.StatusForbidden},
+		{"no role", &auth.Claims{}, auth.PermProductRead, http.StatusForbidden},
+		{"unknown role", &auth.Claims{Role: "root"}, auth.PermProductRead, http.StatusForbidden},
+		{"no token", nil, auth.PermProductRead, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		router := gin.New()
+		router.GET("/", func(c *gin.Context) {
+			if test.claims != nil {
+				c.Set(ClaimsKey, test.claims)
+			}
+		}, RequirePermission(test.permission), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		if recorder.Code != test.want {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.want)
+		}
+	}
+}
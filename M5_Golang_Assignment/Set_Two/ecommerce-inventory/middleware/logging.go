@@ -1,17 +1,133 @@
 package middleware
 
 import (
-	"fmt"
+	"bytes"
+	"ecommerce-inventory/logging"
+	"io"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// Logs request method, path, status, and duration
-func LoggingMiddleware() gin.HandlerFunc {
+// maxSampledBody is how much of a body is kept when bodies are sampled
+const maxSampledBody = 4096
+
+// Logs one structured entry per request with its client, route, status,
+// latency and sizes. The entry carries the request ID when
+// RequestIDMiddleware runs first. A fraction of requests also get their
+// redacted bodies logged, see logging.Options.
+func LoggingMiddleware(logger *slog.Logger, bodySampleRate float64) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		startTime := time.Now()
+
+		sampled := bodySampleRate > 0 && mathrand.Float64() < bodySampleRate
+		body := &countingReader{ReadCloser: c.Request.Body, keep: sampled}
+		c.Request.Body = body
+		var response *sampledWriter
+		if sampled {
+			response = &sampledWriter{ResponseWriter: c.Writer}
+			c.Writer = response
+		}
+
 		c.Next()
-		fmt.Printf("%s %s | Status: %d | Time: %v\n", c.Request.Method, c.Request.URL.Path, c.Writer.Status(), time.Since(startTime))
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(startTime).Microseconds())/1000),
+			slog.Int64("bytes_in", body.count),
+			slog.Int("bytes_out", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if subject := c.GetString(UserKey); subject != "" {
+			attrs = append(attrs, slog.String("subject", subject))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		if sampled {
+			attrs = append(attrs,
+				slog.String("request_body", logging.RedactBody(body.sample.Bytes(), body.truncated)),
+				slog.String("response_body", logging.RedactBody(response.sample.Bytes(), response.truncated)))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// RecoveryMiddleware turns panics into 500 responses and logs them with the
+// request ID instead of printing them to stderr
+func RecoveryMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		logger.ErrorContext(c.Request.Context(), "panic recovered", "route", c.FullPath(), "error", err)
+		WriteProblem(c, http.StatusInternalServerError, "Internal server error")
+		c.Abort()
+	})
+}
+
+// countingReader counts the bytes a handler reads from the request body and
+// optionally keeps the first of them
+type countingReader struct {
+	io.ReadCloser
+	count     int64
+	keep      bool
+	sample    bytes.Buffer
+	truncated bool
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	reader.count += int64(n)
+	if reader.keep {
+		reader.truncated = keepSample(&reader.sample, p[:n]) || reader.truncated
+	}
+	return n, err
+}
+
+// sampledWriter keeps the first bytes of the response body
+type sampledWriter struct {
+	gin.ResponseWriter
+	sample    bytes.Buffer
+	truncated bool
+}
+
+func (writer *sampledWriter) Write(p []byte) (int, error) {
+	writer.truncated = keepSample(&writer.sample, p) || writer.truncated
+	return writer.ResponseWriter.Write(p)
+}
+
+func (writer *sampledWriter) WriteString(s string) (int, error) {
+	writer.truncated = keepSample(&writer.sample, []byte(s)) || writer.truncated
+	return writer.ResponseWriter.WriteString(s)
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (writer *sampledWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// keepSample appends p to sample up to maxSampledBody and reports whether
+// anything was cut off
+func keepSample(sample *bytes.Buffer, p []byte) bool {
+	room := maxSampledBody - sample.Len()
+	if len(p) > room {
+		sample.Write(p[:max(room, 0)])
+		return true
 	}
+	sample.Write(p)
+	return false
 }
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute labels requests that matched no route, so that probing
+// random paths does not create new series
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a method outside the standard set, which
+// clients can make up freely
+const otherMethod = "OTHER"
+
+// methodLabel returns the metrics label for an HTTP method
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return otherMethod
+}
+
+// MetricsMiddleware counts requests and times them, labelled by method,
+// route template and status
+func MetricsMiddleware(registerer prometheus.Registerer) gin.HandlerFunc {
+	labels := []string{"method", "route", "status"}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecommerce_http_requests_total",
+		Help: "Number of HTTP requests handled.",
+	}, labels)
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ecommerce_http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, labels)
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ecommerce_http_requests_in_flight",
+		Help: "Number of HTTP requests being handled.",
+	})
+	registerer.MustRegister(requests, duration, inFlight)
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := methodLabel(c.Request.Method)
+		status := strconv.Itoa(c.Writer.Status())
+		requests.WithLabelValues(method, route, status).Inc()
+		duration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemContentType is the media type of RFC 9457 problem details
+const ProblemContentType = "application/problem+json"
+
+// NewProblem builds the problem details of an error response. Handlers may
+// add extension members before rendering it with RenderProblem.
+func NewProblem(c *gin.Context, status int, detail string) gin.H {
+	return gin.H{
+		"type":       "about:blank",
+		"title":      http.StatusText(status),
+		"status":     status,
+		"detail":     detail,
+		"instance":   c.Request.URL.Path,
+		"request_id": c.GetString(RequestIDKey),
+	}
+}
+
+// RenderProblem writes problem details as an application/problem+json response
+func RenderProblem(c *gin.Context, problem gin.H) {
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(problem["status"].(int), problem)
+}
+
+// WriteProblem writes an error response with the given status and detail
+func WriteProblem(c *gin.Context, status int, detail string) {
+	RenderProblem(c, NewProblem(c, status, detail))
+}
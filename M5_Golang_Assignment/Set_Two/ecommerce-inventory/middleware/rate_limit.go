@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit allows bursts of up to Burst requests, refilled at Requests per Per
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// rate is the number of tokens added to a bucket per second
+func (limit RateLimit) rate() float64 {
+	return float64(limit.Requests) / limit.Per.Seconds()
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, when not allowed
+}
+
+// RateLimitStore holds the token buckets of the rate limiter. Implementations
+// must be safe for concurrent use. A store shared between instances, such as
+// one backed by Redis, can replace MemoryRateLimitStore to limit a whole
+// cluster instead of a single process.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket has refilled to its burst
+}
+
+// MemoryRateLimitStore keeps token buckets in process memory
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+// Take refills the bucket of key for the time passed and takes a token from it
+func (store *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.sweep(now)
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		store.buckets[key] = b
+	}
+
+	rate := limit.rate()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	result := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsToDuration((float64(limit.Burst) - b.tokens) / rate)
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops buckets once a minute so the map does not grow with every
+// client ever seen. A dropped bucket starts out full, so only buckets that
+// would have refilled by now may go.
+func (store *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < time.Minute {
+		return
+	}
+	store.lastSweep = now
+	for key, b := range store.buckets {
+		if !now.Before(b.full) {
+			delete(store.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// RateLimitKeyFunc picks the bucket a request is counted against
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// RateLimitByIP counts requests per client IP address
+func RateLimitByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimitBySubject counts requests per token subject, set by AuthMiddleware,
+// and falls back to the client IP address for anonymous requests
+func RateLimitBySubject(c *gin.Context) string {
+	if subject := c.GetString(UserKey); subject != "" {
+		return "sub:" + subject
+	}
+	return RateLimitByIP(c)
+}
+
+// RateLimitMiddleware limits requests with a token bucket per key. Each scope,
+// usually a route group, has its own buckets. Every response carries the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset (Unix time
+// at which the bucket is full again) headers, and rejected requests get a 429
+// with Retry-After.
+func RateLimitMiddleware(store RateLimitStore, scope string, limit RateLimit, key RateLimitKeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		result, err := store.Take(scope+":"+key(c), limit, now)
+		if err != nil {
+			// Failing open keeps the API up when a shared store is unreachable
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(now.Add(result.Reset).Unix(), 10))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			WriteProblem(c, http.StatusTooManyRequests, "Rate limit exceeded")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
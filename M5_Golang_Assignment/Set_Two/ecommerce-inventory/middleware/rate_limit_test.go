@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreTake(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Requests: 1, Per: time.Second, Burst: 2}
+	now := time.Now()
+
+	for i, want := range []bool{true, true, false} {
+		result, err := store.Take("client", limit, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed != want {
+			t.Errorf("request %d: allowed = %v, want %v", i+1, result.Allowed, want)
+		}
+	}
+	result, _ := store.Take("client", limit, now.Add(time.Second))
+	if !result.Allowed {
+		t.Error("a token was not refilled after a second")
+	}
+	if result, _ := store.Take("other", limit, now); !result.Allowed || result.Remaining != 1 {
+		t.Errorf("another client got %+v, want its own full bucket", result)
+	}
+}
+
+func TestMemoryRateLimitStoreSweep(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Now()
+	fast := RateLimit{Requests: 10, Per: time.Minute, Burst: 5}
+	slow := RateLimit{Requests: 1, Per: time.Hour, Burst: 5}
+
+	// Drain both buckets, so that neither holds a whole token any more
+	for range 5 {
+		store.Take("fast", fast, now)
+		store.Take("slow", slow, now)
+	}
+
+	store.sweep(now.Add(2 * time.Minute))
+	if _, ok := store.buckets["fast"]; ok {
+		t.Error("a drained bucket that has refilled since was not dropped")
+	}
+	if _, ok := store.buckets["slow"]; !ok {
+		t.Fatal("a bucket that is still refilling was dropped")
+	}
+	// Dropping the bucket would hand the client a fresh burst
+	if result, _ := store.Take("slow", slow, now.Add(2*time.Minute)); result.Allowed {
+		t.Error("the slow bucket allowed a request before refilling")
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"ecommerce-inventory/logging"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDKey    = "request_id" // ID of the request, also sent as X-Request-ID
+	RequestIDHeader = "X-Request-ID"
+	TraceParent     = "traceparent"
+)
+
+// maxRequestIDLength bounds the X-Request-ID values accepted from callers
+const maxRequestIDLength = 128
+
+// Gives every request an ID so that our log lines can be tied to the
+// caller's. The ID is taken from X-Request-ID, or else from the trace ID of
+// a W3C traceparent header, or else generated. It is stored on the gin
+// context and the request context, and echoed in the X-Request-ID header.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = traceID(c.GetHeader(TraceParent))
+		}
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+
+		c.Set(RequestIDKey, requestID)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// validRequestID accepts printable ASCII IDs of a sane length, so that
+// callers cannot inject anything into the logs
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < '!' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// traceID returns the trace ID of a version-traceid-parentid-flags
+// traceparent header, or "" if the header is missing or malformed
+func traceID(traceParent string) string {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" ||
+		!isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return ""
+	}
+	// Version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return ""
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return ""
+	}
+	return parts[1]
+}
+
+// isHex reports whether s is n lowercase hex digits
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit ID in hex
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
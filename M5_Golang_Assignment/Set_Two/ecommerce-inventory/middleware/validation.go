@@ -1,16 +1,18 @@
 package middleware
 
 import (
+	"mime"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-// Validates Content-Type header
+// Validates that the body is JSON. Parameters such as charset are allowed.
 func ValidationMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.Header.Get("Content-Type") != "application/json" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid content type"})
+		mediaType, _, err := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			WriteProblem(c, http.StatusUnsupportedMediaType, "Invalid content type, expected application/json")
 			c.Abort()
 			return
 		}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"ecommerce-inventory/config"
+	"ecommerce-inventory/migrations"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: ecommerce-inventory migrate up|down [steps]|status [flags]"
+
+// splitCommand separates a command such as "migrate up" from the flags that
+// follow it
+func splitCommand(args []string) (command, flags []string) {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return args[:i], args[i:]
+		}
+	}
+	return args, nil
+}
+
+// runMigrate runs the migrate command: up applies the pending migrations,
+// down reverts the latest one or the given number of them and status lists
+// them all
+func runMigrate(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := config.OpenDatabase(cfg.Database.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := migrations.Up(db)
+		for _, migration := range applied {
+			fmt.Printf("Applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		return err
+
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		reverted, err := migrations.Down(db, steps)
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("No applied migrations")
+		}
+		return err
+
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrations.Statuses(db)
+		if err != nil && !errors.Is(err, migrations.ErrSchemaTooNew) {
+			return err
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(table, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		table.Flush()
+		return err
+	}
+
+	return errors.New(migrateUsage)
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import "database/sql"
+
+// isLegacySchema reports whether the database was created by a version of
+// the server that predates migrations, which created its tables directly
+func isLegacySchema(db *sql.DB) (bool, error) {
+	migrated, err := tableExists(db, "schema_migrations")
+	if err != nil || migrated {
+		return false, err
+	}
+	return tableExists(db, "users")
+}
+
+// adoptLegacySchema brings a database that predates migrations up to the
+// baseline schema. The baseline only creates what is missing, so this adds
+// the columns that earlier versions added to existing tables.
+func adoptLegacySchema(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "users", "sessions_revoked_at", "DATETIME"); err != nil {
+		return err
+	}
+	// Users registered before roles existed all become viewers. No account is
+	// trusted with admin rights on upgrade, the first admin is added with the
+	// create-admin command and can then grant roles to the others.
+	if err := addColumnIfMissing(tx, "users", "role", "TEXT NOT NULL DEFAULT 'viewer'"); err != nil {
+		return err
+	}
+	if exists, err := tableExists(tx, "products"); err != nil || !exists {
+		return err
+	}
+	return addColumnIfMissing(tx, "products", "category_id", "INTEGER")
+}
+
+// addColumnIfMissing adds a column to a table created by an earlier version
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)`, table, column).
+		Scan(&exists); err != nil || exists {
+		return err
+	}
+	_, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
@@ -0,0 +1,182 @@
+package migrations_test
+
+import (
+	"database/sql"
+	"ecommerce-inventory/config"
+	"ecommerce-inventory/migrations"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := config.OpenDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func allMigrations(t *testing.T) []migrations.Migration {
+	t.Helper()
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return all
+}
+
+func mustUp(t *testing.T, db *sql.DB, want int) {
+	t.Helper()
+	applied, err := migrations.Up(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != want {
+		t.Fatalf("applied %d migrations, want %d", len(applied), want)
+	}
+	pending, err := migrations.Pending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("%d migrations still pending", len(pending))
+	}
+}
+
+func TestUpOnEmptyDatabase(t *testing.T) {
+	db := openDatabase(t)
+	all := allMigrations(t)
+	mustUp(t, db, len(all))
+	mustUp(t, db, 0)
+
+	if _, err := db.Exec(`INSERT INTO products (name, description, price, stock, category_id) VALUES ('Lamp', '', 10, 1, NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	var deletedAt *time.Time
+	if err := db.QueryRow(`SELECT version, deleted_at FROM products`).Scan(&version, &deletedAt); err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || deletedAt != nil {
+		t.Errorf("new product has version %d and deleted_at %v, want 1 and nil", version, deletedAt)
+	}
+}
+
+func TestUpAdoptsLegacySchema(t *testing.T) {
+	db := openDatabase(t)
+	// The tables as the server created them before roles, categories and
+	// migrations existed
+	if _, err := db.Exec(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE,
+		password TEXT
+	);
+	CREATE TABLE products (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		description TEXT,
+		price REAL,
+		stock INTEGER
+	);
+	INSERT INTO users (username, password) VALUES ('alice', 'x'), ('bob', 'y');
+	INSERT INTO products (name, description, price, stock) VALUES ('Lamp', '', 10, 3);`); err != nil {
+		t.Fatal(err)
+	}
+
+	mustUp(t, db, len(allMigrations(t)))
+
+	rows, err := db.Query(`SELECT username, role, sessions_revoked_at FROM users ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username, role string
+		var revokedAt *time.Time
+		if err := rows.Scan(&username, &role, &revokedAt); err != nil {
+			t.Fatal(err)
+		}
+		if role != "viewer" || revokedAt != nil {
+			t.Errorf("legacy user %s has role %q and sessions_revoked_at %v, want viewer and nil", username, role, revokedAt)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	var stock, version int
+	var categoryID *int
+	if err := db.QueryRow(`SELECT name, stock, category_id, version FROM products`).
+		Scan(&name, &stock, &categoryID, &version); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Lamp" || stock != 3 || categoryID != nil || version != 1 {
+		t.Errorf("legacy product is %s with stock %d, category %v and version %d", name, stock, categoryID, version)
+	}
+}
+
+func TestDownThenUp(t *testing.T) {
+	db := openDatabase(t)
+	all := allMigrations(t)
+	mustUp(t, db, len(all))
+
+	reverted, err := migrations.Down(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != len(all) {
+		t.Fatalf("reverted %v, want only migration %d", reverted, len(all))
+	}
+	mustUp(t, db, 1)
+
+	reverted, err = migrations.Down(db, len(all)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(all) {
+		t.Fatalf("reverted %d migrations, want %d", len(reverted), len(all))
+	}
+	statuses, err := migrations.Statuses(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("migration %d is still applied", status.Version)
+		}
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('users', 'products')`).
+		Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables left after reverting every migration", tables)
+	}
+	mustUp(t, db, len(all))
+}
+
+func TestSchemaTooNew(t *testing.T) {
+	db := openDatabase(t)
+	all := allMigrations(t)
+	mustUp(t, db, len(all))
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'from_the_future', ?)`,
+		len(all)+1, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrations.Up(db); !errors.Is(err, migrations.ErrSchemaTooNew) {
+		t.Errorf("Up: got %v, want ErrSchemaTooNew", err)
+	}
+	if _, err := migrations.Pending(db); !errors.Is(err, migrations.ErrSchemaTooNew) {
+		t.Errorf("Pending: got %v, want ErrSchemaTooNew", err)
+	}
+	if _, err := migrations.Down(db, 1); !errors.Is(err, migrations.ErrSchemaTooNew) {
+		t.Errorf("Down: got %v, want ErrSchemaTooNew", err)
+	}
+}
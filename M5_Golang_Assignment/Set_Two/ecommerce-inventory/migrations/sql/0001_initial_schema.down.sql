@@ -0,0 +1,10 @@
+-- products_fts and its triggers are created at startup when SQLite has FTS5
+DROP TABLE IF EXISTS products_fts;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS stock_movements;
+DROP TABLE IF EXISTS reservation_items;
+DROP TABLE IF EXISTS reservations;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS users;
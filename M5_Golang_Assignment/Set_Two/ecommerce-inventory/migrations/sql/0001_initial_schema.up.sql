@@ -0,0 +1,74 @@
+-- The baseline schema. It uses IF NOT EXISTS so that databases created
+-- before migrations existed can adopt it, see adoptLegacySchema.
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE,
+	password TEXT,
+	sessions_revoked_at DATETIME,
+	role TEXT NOT NULL DEFAULT 'viewer'
+);
+
+CREATE TABLE IF NOT EXISTS products (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT,
+	description TEXT,
+	price REAL,
+	stock INTEGER,
+	category_id INTEGER
+);
+
+-- Index the sortable product columns so that keyset pages seek instead of scan
+CREATE INDEX IF NOT EXISTS idx_products_name ON products (name, id);
+CREATE INDEX IF NOT EXISTS idx_products_price ON products (price, id);
+CREATE INDEX IF NOT EXISTS idx_products_stock ON products (stock, id);
+
+CREATE TABLE IF NOT EXISTS categories (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	parent_id INTEGER REFERENCES categories(id)
+);
+CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories (parent_id);
+CREATE INDEX IF NOT EXISTS idx_products_category ON products (category_id, id);
+
+CREATE TABLE IF NOT EXISTS reservations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	status TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS reservation_items (
+	reservation_id INTEGER NOT NULL REFERENCES reservations(id) ON DELETE CASCADE,
+	product_id INTEGER NOT NULL REFERENCES products(id),
+	quantity INTEGER NOT NULL,
+	PRIMARY KEY (reservation_id, product_id)
+);
+CREATE INDEX IF NOT EXISTS idx_reservations_status_expires ON reservations (status, expires_at);
+CREATE INDEX IF NOT EXISTS idx_reservation_items_product ON reservation_items (product_id);
+
+CREATE TABLE IF NOT EXISTS stock_movements (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	product_id INTEGER NOT NULL,
+	delta INTEGER NOT NULL,
+	quantity INTEGER NOT NULL,
+	reason TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_product_created ON stock_movements (product_id, created_at);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	token_hash TEXT NOT NULL UNIQUE,
+	family_id TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens (user_id);
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti TEXT PRIMARY KEY,
+	expires_at DATETIME NOT NULL
+);
@@ -0,0 +1,2 @@
+-- Counts the changes of each product for optimistic concurrency control
+ALTER TABLE products ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+-- Products awaiting purge would come back to life without the column
+DELETE FROM products WHERE deleted_at IS NOT NULL;
+DROP INDEX idx_products_deleted;
+ALTER TABLE products DROP COLUMN deleted_at;
@@ -0,0 +1,4 @@
+-- Deleted products are kept until the purge worker removes them, so that
+-- mistaken deletes can be restored
+ALTER TABLE products ADD COLUMN deleted_at DATETIME;
+CREATE INDEX idx_products_deleted ON products (deleted_at);
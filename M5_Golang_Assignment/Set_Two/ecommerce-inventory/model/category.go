@@ -0,0 +1,8 @@
+package model
+
+// Category groups products. Categories nest through ParentID.
+type Category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ProductCursor marks the last product of a keyset page by its values for
+// the sort keys of the listing. The keys are those returned by StableSort, so
+// the last value is always the id of the product.
+type ProductCursor struct {
+	Sort   string `json:"s"`
+	Values []any  `json:"v"`
+}
+
+// NewProductCursor positions a cursor right after product for the given sort
+func NewProductCursor(product Product, sort []SortKey) ProductCursor {
+	cursor := ProductCursor{Sort: FormatSort(sort)}
+	for _, key := range StableSort(sort) {
+		cursor.Values = append(cursor.Values, product.SortValue(key.Field))
+	}
+	return cursor
+}
+
+// Encode turns the cursor into an opaque URL-safe token
+func (cursor ProductCursor) Encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeProductCursor reads a token made by ProductCursor.Encode
+func DecodeProductCursor(token string) (*ProductCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	cursor := &ProductCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	return cursor, nil
+}
+
+// Matches checks that the cursor was made for the given sort, holding a value
+// of the right type for each of its keys: a string for the name and a number
+// for the others
+func (cursor ProductCursor) Matches(sort []SortKey) error {
+	keys := StableSort(sort)
+	if cursor.Sort != FormatSort(sort) || len(cursor.Values) != len(keys) {
+		return errors.New("cursor does not match the sort order")
+	}
+	for i, key := range keys {
+		var ok bool
+		switch cursor.Values[i].(type) {
+		case string:
+			ok = key.Field == "name"
+		case float64:
+			ok = key.Field != "name"
+		}
+		if !ok {
+			return fmt.Errorf("cursor has an invalid %s value", key.Field)
+		}
+	}
+	return nil
+}
+
+// StableSort makes the id the final sort key, dropping the keys after it as
+// they can never change the order
+func StableSort(sort []SortKey) []SortKey {
+	var keys []SortKey
+	for _, key := range sort {
+		keys = append(keys, key)
+		if key.Field == "id" {
+			return keys
+		}
+	}
+	return append(keys, SortKey{Field: "id"})
+}
+
+// FormatSort writes sort keys in the sort=price,-stock query parameter format
+func FormatSort(sort []SortKey) string {
+	var formatted string
+	for i, key := range sort {
+		if i > 0 {
+			formatted += ","
+		}
+		if key.Desc {
+			formatted += "-"
+		}
+		formatted += key.Field
+	}
+	return formatted
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestProductCursorMatches(t *testing.T) {
+	byName := []SortKey{{Field: "name"}}
+	byPrice := []SortKey{{Field: "price", Desc: true}}
+	product := Product{ID: 7, Name: "Lamp", Price: 20}
+
+	tests := []struct {
+		name  string
+		token string
+		sort  []SortKey
+		ok    bool
+	}{
+		{"made for the sort", NewProductCursor(product, byName).Encode(), byName, true},
+		{"made for another sort", NewProductCursor(product, byName).Encode(), byPrice, false},
+		{"object value", `{"s":"-price","v":[{"a":1},7]}`, byPrice, false},
+		{"array id", `{"s":"-price","v":[20,[7]]}`, byPrice, false},
+		{"numeric name", `{"s":"name","v":[1,7]}`, byName, false},
+		{"string price", `{"s":"-price","v":["20",7]}`, byPrice, false},
+		{"null id", `{"s":"name","v":["Lamp",null]}`, byName, false},
+		{"missing value", `{"s":"name","v":["Lamp"]}`, byName, false},
+	}
+	for _, test := range tests {
+		token := test.token
+		if token[0] == '{' {
+			token = base64.RawURLEncoding.EncodeToString([]byte(token))
+		}
+		cursor, err := DecodeProductCursor(token)
+		if err != nil {
+			t.Fatalf("%s: DecodeProductCursor: %v", test.name, err)
+		}
+		if err := cursor.Matches(test.sort); (err == nil) != test.ok {
+			t.Errorf("%s: Matches = %v, want ok %v", test.name, err, test.ok)
+		}
+	}
+}
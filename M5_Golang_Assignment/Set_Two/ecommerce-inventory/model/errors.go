@@ -0,0 +1,38 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of domain errors. Errors returned by the repositories and services
+// wrap one of these so that callers can tell what went wrong without
+// matching messages.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("invalid input")
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrPreconditionFailed means the client changed a resource based on a
+	// version of it that is no longer current
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Error is a domain error of one of the kinds above
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// NewError creates an error of the given kind
+func NewError(kind error, format string, args ...any) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
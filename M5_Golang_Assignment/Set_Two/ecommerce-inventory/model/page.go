@@ -0,0 +1,25 @@
+package model
+
+// Page is one page of a paginated listing
+type Page[T any] struct {
+	Items []T       `json:"items"`
+	Total int       `json:"total"`
+	Page  int       `json:"page"`
+	Limit int       `json:"limit"`
+	Links PageLinks `json:"links"`
+}
+
+// PageLinks point at the neighbouring pages of a listing
+type PageLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// CursorPage is one page of a keyset paginated listing. NextCursor is empty
+// on the last page.
+type CursorPage[T any] struct {
+	Items      []T       `json:"items"`
+	Limit      int       `json:"limit"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Links      PageLinks `json:"links"`
+}
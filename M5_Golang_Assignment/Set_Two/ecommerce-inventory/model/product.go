@@ -1,10 +1,59 @@
 package model
 
+import "time"
+
+// Product is an item of the inventory. The validate tags hold the rules a
+// product must follow, see the validation package.
 type Product struct {
 	ID          int     `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Stock       int     `json:"stock"`
-	CategoryID  int     `json:"category_id"`
+	Name        string  `json:"name" validate:"required,max=200"`
+	Description string  `json:"description" validate:"max=2000"`
+	Price       float64 `json:"price" validate:"gt=0,lte=1000000"`
+	Stock       int     `json:"stock" validate:"gte=0,lte=1000000000"`
+	Available   int     `json:"available"`
+	CategoryID  int     `json:"category_id" validate:"gte=0"`
+	// Version is incremented by every change, so that an update can be made
+	// conditional on the product not having changed since it was read
+	Version int `json:"version"`
+	// DeletedAt is set when the product is deleted. It can be restored until
+	// it is purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// SortValue is the value of one of the ProductSortFields
+func (product Product) SortValue(field string) any {
+	switch field {
+	case "name":
+		return product.Name
+	case "price":
+		return product.Price
+	case "stock":
+		return product.Stock
+	case "category_id":
+		return product.CategoryID
+	}
+	return product.ID
+}
+
+// ProductSortFields are the fields a product listing can be sorted by
+var ProductSortFields = []string{"id", "name", "price", "stock", "category_id"}
+
+// SortKey orders a listing by a single field
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ProductFilter narrows down and orders a product listing
+type ProductFilter struct {
+	Query              string
+	MinPrice           *float64
+	MaxPrice           *float64
+	CategoryID         *int
+	IncludeDescendants bool
+	InStock            *bool
+	IncludeDeleted     bool
+	Sort               []SortKey
+	Page               int
+	Limit              int
 }
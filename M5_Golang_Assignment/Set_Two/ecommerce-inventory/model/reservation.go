@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// Reservation statuses
+const (
+	ReservationActive    = "active"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// Reservation holds stock for a checkout until it is committed, released or expires
+type Reservation struct {
+	ID        int               `json:"id"`
+	Status    string            `json:"status"`
+	Items     []ReservationItem `json:"items"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ReservationItem is the quantity of a single product held by a reservation
+type ReservationItem struct {
+	ProductID int `json:"product_id"`
+	Quantity  int `json:"quantity"`
+}
@@ -0,0 +1,71 @@
+package model
+
+import "time"
+
+// Stock movement reasons
+const (
+	ReasonRestock    = "restock"
+	ReasonSale       = "sale"
+	ReasonAdjustment = "adjustment"
+	ReasonReturn     = "return"
+)
+
+// ValidMovementReason reports whether reason is a known stock movement reason
+func ValidMovementReason(reason string) bool {
+	switch reason {
+	case ReasonRestock, ReasonSale, ReasonAdjustment, ReasonReturn:
+		return true
+	}
+	return false
+}
+
+// StockAdjustment is a single line of a stock adjustment batch
+type StockAdjustment struct {
+	ProductID int    `json:"product_id"`
+	Delta     int    `json:"delta"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// StockLevel is the stock left on a product after an adjustment
+type StockLevel struct {
+	ProductID int `json:"product_id"`
+	Stock     int `json:"stock"`
+}
+
+// StockLineError describes why a line of a stock adjustment or reservation was rejected
+type StockLineError struct {
+	Line      int    `json:"line"`
+	ProductID int    `json:"product_id"`
+	Delta     int    `json:"delta"`
+	Available *int   `json:"available,omitempty"`
+	Error     string `json:"error"`
+}
+
+// StockMovement is a single entry of the stock ledger of a product
+type StockMovement struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	Delta     int       `json:"delta"`
+	Quantity  int       `json:"quantity"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StockMovementFilter narrows down the stock movements of a product
+type StockMovementFilter struct {
+	From  *time.Time
+	To    *time.Time
+	Page  int
+	Limit int
+}
+
+// InventoryStats sums up the whole inventory
+type InventoryStats struct {
+	// Products is the number of SKUs
+	Products int
+	// OutOfStock is the number of products with no available stock
+	OutOfStock int
+	// Value is the price of the stock on hand
+	Value float64
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// RefreshToken is a single use token exchanged for a new access token. Only
+// a hash of the token is stored. Every rotation stays in the family of the
+// token issued at login, so that reuse of a rotated token revokes them all.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	FamilyID  string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// TokenPair is the response to a login or a token refresh
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
@@ -1,7 +1,11 @@
 package model
 
+// User is an account allowed to log in. Password holds the password hash and
+// is never serialized. The validate tags hold the rules for registering, when
+// Password is still the plaintext password.
 type User struct {
 	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,min=3,max=64"`
+	Password string `json:"-" validate:"required,min=8,max=72"`
+	Role     string `json:"role" validate:"omitempty,oneof=admin manager viewer"`
 }
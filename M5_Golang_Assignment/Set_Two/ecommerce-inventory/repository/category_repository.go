@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"database/sql"
+	"ecommerce-inventory/model"
+)
+
+var (
+	ErrCategoryNotFound = model.NewError(model.ErrNotFound, "category not found")
+	ErrCategoryInUse    = model.NewError(model.ErrConflict, "category still has subcategories or products")
+	ErrCategoryCycle    = model.NewError(model.ErrConflict, "category cannot be nested under itself or its descendants")
+)
+
+type CategoryRepository struct {
+	db *sql.DB
+}
+
+func NewCategoryRepository(db *sql.DB) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+// Add a category
+func (repo *CategoryRepository) AddCategory(category *model.Category) error {
+	result, err := repo.db.Exec(`INSERT INTO categories (name, parent_id) VALUES (?, ?)`, category.Name, category.ParentID)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	category.ID = int(id)
+	return nil
+}
+
+// Get a category by ID
+func (repo *CategoryRepository) GetCategoryByID(id int) (*model.Category, error) {
+	row := repo.db.QueryRow(`SELECT id, name, parent_id FROM categories WHERE id = ?`, id)
+	category := &model.Category{}
+	if err := row.Scan(&category.ID, &category.Name, &category.ParentID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, err
+	}
+	return category, nil
+}
+
+// Get all categories
+func (repo *CategoryRepository) GetAllCategories() ([]model.Category, error) {
+	rows, err := repo.db.Query(`SELECT id, name, parent_id FROM categories ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []model.Category{}
+	for rows.Next() {
+		var category model.Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.ParentID); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// Update a category, refusing to move it under one of its own descendants
+func (repo *CategoryRepository) UpdateCategory(category *model.Category) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if category.ParentID != nil {
+		// Walk up from the new parent; reaching the category itself means a cycle
+		var cycle bool
+		err := tx.QueryRow(`WITH RECURSIVE ancestors(id) AS (
+				SELECT ?
+				UNION
+				SELECT c.parent_id FROM categories c JOIN ancestors a ON c.id = a.id WHERE c.parent_id IS NOT NULL
+			)
+			SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = ?)`, *category.ParentID, category.ID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrCategoryCycle
+		}
+	}
+
+	result, err := tx.Exec(`UPDATE categories SET name = ?, parent_id = ? WHERE id = ?`,
+		category.Name, category.ParentID, category.ID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrCategoryNotFound
+	}
+	return tx.Commit()
+}
+
+// Delete a category that has no subcategories and no products. Deleted
+// products count until they are purged, since they may be restored.
+func (repo *CategoryRepository) DeleteCategory(id int) error {
+	result, err := repo.db.Exec(`DELETE FROM categories WHERE id = ?
+		AND NOT EXISTS (SELECT 1 FROM categories WHERE parent_id = ?)
+		AND NOT EXISTS (SELECT 1 FROM products WHERE category_id = ?)`, id, id, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 1 {
+		return nil
+	}
+
+	if _, err := repo.GetCategoryByID(id); err != nil {
+		return err
+	}
+	return ErrCategoryInUse
+}
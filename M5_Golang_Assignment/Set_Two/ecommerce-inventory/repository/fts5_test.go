@@ -0,0 +1,57 @@
+//go:build sqlite_fts5
+
+package repository_test
+
+import (
+	"context"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"testing"
+)
+
+// Run with go test -tags sqlite_fts5, see the Makefile. Search then goes
+// through the products_fts index, which matches every word as a prefix in
+// any order where the LIKE fallback would match nothing.
+func TestProductSearchUsesFullTextIndex(t *testing.T) {
+	db := openDatabase(t)
+	var indexed bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'products_fts_insert')`).
+		Scan(&indexed); err != nil {
+		t.Fatal(err)
+	}
+	if !indexed {
+		t.Fatal("the products_fts index was not created")
+	}
+
+	repo := repository.NewProductRepository(db)
+	for _, product := range []model.Product{
+		{Name: "Keyboard", Description: "Mechanical keyboard for a laptop", Price: 80},
+		{Name: "Mouse", Description: "Wireless mouse", Price: 25},
+	} {
+		if err := repo.AddProduct(context.Background(), &product, "tester"); err != nil {
+			t.Fatalf("AddProduct: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"mech keyb", 1},
+		{"LAPTOP mechanical", 1},
+		{"wire", 1},
+		{`"laptop`, 1},
+		{"keyboard wireless", 0},
+	}
+	for _, test := range tests {
+		filter := model.ProductFilter{Query: test.query, Page: 1, Limit: 10}
+		_, total, err := repo.GetAllProducts(context.Background(), filter)
+		if err != nil {
+			t.Errorf("search for %q: %v", test.query, err)
+			continue
+		}
+		if total != test.want {
+			t.Errorf("search for %q found %d products, want %d", test.query, total, test.want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package memory_test
+
+import (
+	"ecommerce-inventory/repository"
+	"ecommerce-inventory/repository/memory"
+	"ecommerce-inventory/repository/storetest"
+	"testing"
+)
+
+func TestProductStore(t *testing.T) {
+	storetest.RunProductStoreTests(t, func(t *testing.T) repository.ProductStore {
+		return memory.NewProductStore(nil)
+	})
+}
+
+func TestUserStore(t *testing.T) {
+	storetest.RunUserStoreTests(t, func(t *testing.T) repository.UserStore {
+		return memory.NewUserStore()
+	})
+}
@@ -0,0 +1,458 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CategoryLister lists the category tree, so that listings can include the
+// products of subcategories
+type CategoryLister interface {
+	GetAllCategories() ([]model.Category, error)
+}
+
+// ProductStore is a repository.ProductStore kept in memory. It has no
+// reservations, so the available stock of a product is its whole stock.
+// It is safe for concurrent use.
+type ProductStore struct {
+	mu             sync.RWMutex
+	products       map[int]model.Product
+	movements      []model.StockMovement
+	lastID         int
+	lastMovementID int
+	categories     CategoryLister
+}
+
+var _ repository.ProductStore = (*ProductStore)(nil)
+
+// NewProductStore creates an empty store. Without categories, a listing
+// including descendants only matches the category itself.
+func NewProductStore(categories CategoryLister) *ProductStore {
+	return &ProductStore{products: map[int]model.Product{}, categories: categories}
+}
+
+// Add a product, recording its opening stock in the stock ledger
+func (store *ProductStore) AddProduct(ctx context.Context, product *model.Product, actor string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.lastID++
+	product.ID = store.lastID
+	product.Version = 1
+	stored := *product
+	stored.Available = stored.Stock
+	store.products[product.ID] = stored
+	if product.Stock != 0 {
+		store.recordMovement(product.ID, product.Stock, product.Stock, model.ReasonRestock, actor)
+	}
+	return nil
+}
+
+// Add several products, setting their IDs and versions. Nothing can fail
+// part way, so either all of them are added or none is.
+func (store *ProductStore) AddProducts(ctx context.Context, products []model.Product, actor string) error {
+	for i := range products {
+		if err := store.AddProduct(ctx, &products[i], actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get a product by ID
+func (store *ProductStore) GetProductByID(ctx context.Context, id int) (*model.Product, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	product, ok := store.products[id]
+	if !ok || product.DeletedAt != nil {
+		return nil, repository.ErrProductNotFound
+	}
+	return &product, nil
+}
+
+// Update a product, recording any change of stock in the stock ledger. The
+// update only applies if product.Version is still the current version, unless
+// it is AnyVersion, and bumps it.
+func (store *ProductStore) UpdateProduct(ctx context.Context, product *model.Product, actor string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	old, ok := store.products[product.ID]
+	if !ok || old.DeletedAt != nil {
+		return repository.ErrProductNotFound
+	}
+	if product.Version != old.Version && product.Version != repository.AnyVersion {
+		return repository.ErrProductVersionMismatch
+	}
+	product.Version = old.Version + 1
+	updated := *product
+	updated.Available = updated.Stock
+	store.products[product.ID] = updated
+	if delta := product.Stock - old.Stock; delta != 0 {
+		store.recordMovement(product.ID, delta, product.Stock, model.ReasonAdjustment, actor)
+	}
+	return nil
+}
+
+// Update only the given fields of a product, taking their values from
+// product. Like UpdateProduct it only applies to the current version, bumps
+// it and records any change of stock.
+func (store *ProductStore) UpdateProductFields(ctx context.Context, product *model.Product, fields []string, actor string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	updated, ok := store.products[product.ID]
+	if !ok || updated.DeletedAt != nil {
+		return repository.ErrProductNotFound
+	}
+	if product.Version != updated.Version && product.Version != repository.AnyVersion {
+		return repository.ErrProductVersionMismatch
+	}
+	old := updated
+	for _, field := range fields {
+		switch field {
+		case "name":
+			updated.Name = product.Name
+		case "description":
+			updated.Description = product.Description
+		case "price":
+			updated.Price = product.Price
+		case "stock":
+			updated.Stock = product.Stock
+			updated.Available = product.Stock
+		case "category_id":
+			updated.CategoryID = product.CategoryID
+		default:
+			return fmt.Errorf("cannot update product field %q", field)
+		}
+	}
+	updated.Version++
+	store.products[product.ID] = updated
+	product.Version = updated.Version
+	if delta := updated.Stock - old.Stock; delta != 0 {
+		store.recordMovement(product.ID, delta, updated.Stock, model.ReasonAdjustment, actor)
+	}
+	return nil
+}
+
+// Delete a product, keeping it until it is purged so that it can be restored.
+// Deleting bumps the version.
+func (store *ProductStore) DeleteProduct(ctx context.Context, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	product, ok := store.products[id]
+	if !ok || product.DeletedAt != nil {
+		return repository.ErrProductNotFound
+	}
+	now := time.Now().UTC()
+	product.DeletedAt = &now
+	product.Version++
+	store.products[id] = product
+	return nil
+}
+
+// Restore a deleted product, bumping its version
+func (store *ProductStore) RestoreProduct(ctx context.Context, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	product, ok := store.products[id]
+	if !ok {
+		return repository.ErrProductNotFound
+	}
+	if product.DeletedAt == nil {
+		return repository.ErrProductNotDeleted
+	}
+	product.DeletedAt = nil
+	product.Version++
+	store.products[id] = product
+	return nil
+}
+
+// Delete for good the products deleted before a time, returning how many
+// were purged. Their stock ledgers are kept.
+func (store *ProductStore) PurgeDeletedProducts(ctx context.Context, before time.Time) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var purged int
+	for id, product := range store.products {
+		if product.DeletedAt != nil && product.DeletedAt.Before(before) {
+			delete(store.products, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Get the products matching a filter with pagination, along with the
+// total number of matching products
+func (store *ProductStore) GetAllProducts(ctx context.Context, filter model.ProductFilter) ([]model.Product, int, error) {
+	products, err := store.matching(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	start := min((filter.Page-1)*filter.Limit, len(products))
+	end := min(start+filter.Limit, len(products))
+	return products[start:end], len(products), nil
+}
+
+// Get up to filter.Limit products matching a filter that sort after cursor,
+// or from the start when cursor is nil
+func (store *ProductStore) GetProductsAfter(ctx context.Context, filter model.ProductFilter, cursor *model.ProductCursor) ([]model.Product, error) {
+	products, err := store.matching(filter)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		sort := model.StableSort(filter.Sort)
+		products = slices.DeleteFunc(products, func(product model.Product) bool {
+			return compareToCursor(product, sort, cursor.Values) <= 0
+		})
+	}
+	return products[:min(filter.Limit, len(products))], nil
+}
+
+// Call fn with every product matching a filter in the order of the listing,
+// ignoring its page and limit. The products are a snapshot taken before the
+// first call, so fn may use the store.
+func (store *ProductStore) EachProduct(ctx context.Context, filter model.ProductFilter, fn func(model.Product) error) error {
+	products, err := store.matching(filter)
+	if err != nil {
+		return err
+	}
+	for _, product := range products {
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matching returns every product matching a filter in the order of the listing
+func (store *ProductStore) matching(filter model.ProductFilter) ([]model.Product, error) {
+	categories, err := store.categoryIDs(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	products := []model.Product{}
+	for _, product := range store.products {
+		if matches(product, filter, categories) {
+			products = append(products, product)
+		}
+	}
+	store.mu.RUnlock()
+
+	sort := model.StableSort(filter.Sort)
+	slices.SortFunc(products, func(a, b model.Product) int {
+		for _, key := range sort {
+			if c := compareValues(a.SortValue(key.Field), b.SortValue(key.Field)); c != 0 {
+				if key.Desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	})
+	return products, nil
+}
+
+// categoryIDs returns the categories a filter matches, or nil for any
+func (store *ProductStore) categoryIDs(filter model.ProductFilter) (map[int]bool, error) {
+	if filter.CategoryID == nil {
+		return nil, nil
+	}
+	ids := map[int]bool{*filter.CategoryID: true}
+	if !filter.IncludeDescendants || store.categories == nil {
+		return ids, nil
+	}
+
+	categories, err := store.categories.GetAllCategories()
+	if err != nil {
+		return nil, err
+	}
+	// Categories can be listed before their parents, so repeat until no
+	// more descendants turn up
+	for found := true; found; {
+		found = false
+		for _, category := range categories {
+			if category.ParentID != nil && ids[*category.ParentID] && !ids[category.ID] {
+				ids[category.ID] = true
+				found = true
+			}
+		}
+	}
+	return ids, nil
+}
+
+// matches applies the conditions of a filter the way the SQLite store does,
+// matching the query as a case-insensitive substring of the name or description
+func matches(product model.Product, filter model.ProductFilter, categories map[int]bool) bool {
+	if product.DeletedAt != nil && !filter.IncludeDeleted {
+		return false
+	}
+	if query := strings.ToLower(filter.Query); query != "" &&
+		!strings.Contains(strings.ToLower(product.Name), query) &&
+		!strings.Contains(strings.ToLower(product.Description), query) {
+		return false
+	}
+	if filter.MinPrice != nil && product.Price < *filter.MinPrice {
+		return false
+	}
+	if filter.MaxPrice != nil && product.Price > *filter.MaxPrice {
+		return false
+	}
+	if categories != nil && !categories[product.CategoryID] {
+		return false
+	}
+	if filter.InStock != nil && (product.Available > 0) != *filter.InStock {
+		return false
+	}
+	return true
+}
+
+// compareToCursor compares a product with the sort values of a cursor
+func compareToCursor(product model.Product, sort []model.SortKey, values []any) int {
+	for i, key := range sort {
+		if c := compareValues(product.SortValue(key.Field), values[i]); c != 0 {
+			if key.Desc {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues compares sort values. Numbers decoded from a cursor are
+// float64 while product fields may be ints, so numbers compare as floats.
+func compareValues(a, b any) int {
+	if a, ok := a.(string); ok {
+		b, _ := b.(string)
+		return strings.Compare(a, b)
+	}
+	return cmp.Compare(toFloat(a), toFloat(b))
+}
+
+func toFloat(value any) float64 {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+	case float64:
+		return value
+	}
+	return 0
+}
+
+// Adjust the stock of several products, applying every line or none of them
+func (store *ProductStore) AdjustStock(ctx context.Context, adjustments []model.StockAdjustment, actor string) ([]model.StockLevel, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// Work on the stock levels alone so that nothing changes on failure
+	stock := map[int]int{}
+	var levels []model.StockLevel
+	var lineErrors []model.StockLineError
+	for i, adjustment := range adjustments {
+		product, ok := store.products[adjustment.ProductID]
+		if !ok || product.DeletedAt != nil {
+			lineErrors = append(lineErrors, model.StockLineError{
+				Line: i + 1, ProductID: adjustment.ProductID, Delta: adjustment.Delta, Error: "product not found",
+			})
+			continue
+		}
+		current, adjusted := stock[product.ID]
+		if !adjusted {
+			current = product.Stock
+		}
+		if current+adjustment.Delta < 0 {
+			available := current
+			lineErrors = append(lineErrors, model.StockLineError{
+				Line: i + 1, ProductID: product.ID, Delta: adjustment.Delta, Available: &available, Error: "insufficient stock",
+			})
+			continue
+		}
+		stock[product.ID] = current + adjustment.Delta
+		levels = append(levels, model.StockLevel{ProductID: product.ID, Stock: current + adjustment.Delta})
+	}
+
+	if len(lineErrors) > 0 {
+		return nil, &repository.StockError{Operation: "stock adjustment", Lines: lineErrors}
+	}
+	for i, adjustment := range adjustments {
+		product := store.products[adjustment.ProductID]
+		product.Stock = levels[i].Stock
+		product.Available = product.Stock
+		product.Version++
+		store.products[product.ID] = product
+		store.recordMovement(product.ID, adjustment.Delta, product.Stock, adjustment.Reason, actor)
+	}
+	return levels, nil
+}
+
+// Get the number of products, how many are out of stock and the value of
+// the stock on hand
+func (store *ProductStore) GetInventoryStats(ctx context.Context) (model.InventoryStats, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var stats model.InventoryStats
+	for _, product := range store.products {
+		if product.DeletedAt != nil {
+			continue
+		}
+		stats.Products++
+		if product.Available <= 0 {
+			stats.OutOfStock++
+		}
+		stats.Value += product.Price * float64(product.Stock)
+	}
+	return stats, nil
+}
+
+// Get the stock ledger of a product, newest first
+func (store *ProductStore) GetStockMovements(ctx context.Context, productID int, filter model.StockMovementFilter) ([]model.StockMovement, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	movements := []model.StockMovement{}
+	for i := len(store.movements) - 1; i >= 0; i-- {
+		movement := store.movements[i]
+		if movement.ProductID != productID ||
+			filter.From != nil && movement.CreatedAt.Before(*filter.From) ||
+			filter.To != nil && !movement.CreatedAt.Before(*filter.To) {
+			continue
+		}
+		movements = append(movements, movement)
+	}
+	start := min((filter.Page-1)*filter.Limit, len(movements))
+	end := min(start+filter.Limit, len(movements))
+	return movements[start:end], nil
+}
+
+// recordMovement appends a change of stock to the ledger. The caller holds
+// the write lock.
+func (store *ProductStore) recordMovement(productID, delta, quantity int, reason, actor string) {
+	store.lastMovementID++
+	store.movements = append(store.movements, model.StockMovement{
+		ID:        store.lastMovementID,
+		ProductID: productID,
+		Delta:     delta,
+		Quantity:  quantity,
+		Reason:    reason,
+		Actor:     actor,
+		CreatedAt: time.Now().UTC(),
+	})
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"sync"
+)
+
+// UserStore is a repository.UserStore kept in memory. It is safe for
+// concurrent use.
+type UserStore struct {
+	mu     sync.RWMutex
+	users  map[int]model.User
+	lastID int
+}
+
+var _ repository.UserStore = (*UserStore)(nil)
+
+// NewUserStore creates an empty store
+func NewUserStore() *UserStore {
+	return &UserStore{users: map[int]model.User{}}
+}
+
+// Get user by username
+func (store *UserStore) GetUserByUsername(username string) (*model.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, user := range store.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+// Get user by ID
+func (store *UserStore) GetUserByID(id int) (*model.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	user, ok := store.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+// Register a new user with the given role
+func (store *UserStore) RegisterUser(user *model.User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, existing := range store.users {
+		if existing.Username == user.Username {
+			return repository.ErrUsernameTaken
+		}
+	}
+	store.lastID++
+	user.ID = store.lastID
+	store.users[user.ID] = *user
+	return nil
+}
+
+// Replace the password hash of a user
+func (store *UserStore) UpdatePassword(id int, password string) error {
+	return store.update(id, func(user *model.User) { user.Password = password })
+}
+
+// Change the role of a user
+func (store *UserStore) UpdateRole(id int, role string) error {
+	return store.update(id, func(user *model.User) { user.Role = role })
+}
+
+func (store *UserStore) update(id int, change func(user *model.User)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	user, ok := store.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	change(&user)
+	store.users[id] = user
+	return nil
+}
@@ -1,68 +1,694 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"ecommerce-inventory/model"
-	"errors"
+	"ecommerce-inventory/tracing"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
 )
 
+// AnyVersion as the version of an update makes it unconditional
+const AnyVersion = 0
+
+var (
+	ErrProductNotFound        = model.NewError(model.ErrNotFound, "product not found")
+	ErrProductVersionMismatch = model.NewError(model.ErrPreconditionFailed, "product has changed since it was read")
+	ErrProductNotDeleted      = model.NewError(model.ErrConflict, "product is not deleted")
+)
+
+// StockError is returned when one or more lines of a stock adjustment or
+// reservation cannot be applied. No line of the batch is applied.
+type StockError struct {
+	Operation string
+	Lines     []model.StockLineError
+}
+
+func (e *StockError) Error() string {
+	return e.Operation + " rejected"
+}
+
+func (e *StockError) Unwrap() error {
+	return model.ErrConflict
+}
+
+// heldStockSQL sums the quantity of products.id held by active reservations.
+// It expects the current time as its only parameter.
+const heldStockSQL = `COALESCE((SELECT SUM(ri.quantity) FROM reservation_items ri
+	JOIN reservations r ON r.id = ri.reservation_id
+	WHERE ri.product_id = products.id AND r.status = 'active' AND r.expires_at > ?), 0)`
+
 type ProductRepository struct {
-	db *sql.DB
+	db             *sql.DB
+	fullTextSearch bool
 }
 
+// NewProductRepository searches products through the products_fts index when
+// the database has one and falls back to LIKE matching otherwise
 func NewProductRepository(db *sql.DB) *ProductRepository {
-	return &ProductRepository{db: db}
+	var fullTextSearch bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'products_fts_insert')`).
+		Scan(&fullTextSearch); err != nil {
+		log.Println("Error checking for the product search index:", err)
+	}
+	return &ProductRepository{db: db, fullTextSearch: fullTextSearch}
+}
+
+// Add a product, recording its opening stock in the stock ledger
+func (repo *ProductRepository) AddProduct(ctx context.Context, product *model.Product, actor string) (err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.AddProduct", "INSERT", "products")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := insertProduct(ctx, tx, product, actor)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	product.ID = id
+	product.Version = 1
+	return nil
+}
+
+// Add several products in one transaction, setting their IDs and versions.
+// Either every product is added or none is.
+func (repo *ProductRepository) AddProducts(ctx context.Context, products []model.Product, actor string) (err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.AddProducts", "INSERT", "products")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(products))
+	for i := range products {
+		if ids[i], err = insertProduct(ctx, tx, &products[i], actor); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].ID = ids[i]
+		products[i].Version = 1
+	}
+	return nil
 }
 
-// Add a product
-func (repo *ProductRepository) AddProduct(product *model.Product) error {
-	_, err := repo.db.Exec(`INSERT INTO products (name, description, price, stock, category_id) 
+// insertProduct inserts a product and records its opening stock, returning
+// its ID
+func insertProduct(ctx context.Context, tx *sql.Tx, product *model.Product, actor string) (int, error) {
+	result, err := tx.ExecContext(ctx, `INSERT INTO products (name, description, price, stock, category_id) 
 		VALUES (?, ?, ?, ?, ?)`, product.Name, product.Description, product.Price, product.Stock, product.CategoryID)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if product.Stock != 0 {
+		if err := insertStockMovement(ctx, tx, int(id), product.Stock, product.Stock, model.ReasonRestock, actor); err != nil {
+			return 0, err
+		}
+	}
+	return int(id), nil
 }
 
 // Get a product by ID
-func (repo *ProductRepository) GetProductByID(id int) (*model.Product, error) {
-	row := repo.db.QueryRow(`SELECT id, name, description, price, stock, category_id FROM products WHERE id = ?`, id)
-	product := &model.Product{}
-	if err := row.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.Stock, &product.CategoryID); err != nil {
+func (repo *ProductRepository) GetProductByID(ctx context.Context, id int) (product *model.Product, err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.GetProductByID", "SELECT", "products")
+	defer func() { tracing.End(span, err) }()
+
+	row := repo.db.QueryRowContext(ctx, `SELECT id, name, description, price, stock, stock - `+heldStockSQL+`, category_id, version, deleted_at
+		FROM products WHERE id = ? AND deleted_at IS NULL`, time.Now().UTC(), id)
+	product = &model.Product{}
+	if err := scanProduct(row, product); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("product not found")
+			return nil, ErrProductNotFound
 		}
 		return nil, err
 	}
 	return product, nil
 }
 
-// Update a product
-func (repo *ProductRepository) UpdateProduct(product *model.Product) error {
-	_, err := repo.db.Exec(`UPDATE products SET name = ?, description = ?, price = ?, stock = ?, category_id = ? 
-		WHERE id = ?`, product.Name, product.Description, product.Price, product.Stock, product.CategoryID, product.ID)
-	return err
+// Update a product, recording any change of stock in the stock ledger. The
+// update only applies if product.Version is still the current version, unless
+// it is AnyVersion, and bumps it. The stock may not be lowered below what
+// active reservations hold, which fails with a *StockError.
+func (repo *ProductRepository) UpdateProduct(ctx context.Context, product *model.Product, actor string) (err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.UpdateProduct", "UPDATE", "products")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	current, err := getUpdatedProduct(ctx, tx, product.ID, now)
+	if err != nil {
+		return err
+	}
+	stock := current.stock
+
+	// The version check is part of the UPDATE so that two concurrent updates
+	// of the same version cannot both succeed. Like AdjustStock, lowering the
+	// stock may not eat into stock held by active reservations.
+	var version int
+	err = tx.QueryRowContext(ctx, `UPDATE products SET name = ?, description = ?, price = ?, stock = ?, category_id = ?,
+		version = version + 1 WHERE id = ? AND ? IN (version, 0) AND (? >= stock OR ? >= `+heldStockSQL+`) RETURNING version`,
+		product.Name, product.Description, product.Price, product.Stock, product.CategoryID, product.ID, product.Version,
+		product.Stock, product.Stock, now).Scan(&version)
+	if err == sql.ErrNoRows {
+		return current.rejected(product)
+	}
+	if err != nil {
+		return err
+	}
+
+	if delta := product.Stock - stock; delta != 0 {
+		if err := insertStockMovement(ctx, tx, product.ID, delta, product.Stock, model.ReasonAdjustment, actor); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	product.Version = version
+	return nil
 }
 
-// Delete a product
-func (repo *ProductRepository) DeleteProduct(id int) error {
-	_, err := repo.db.Exec(`DELETE FROM products WHERE id = ?`, id)
-	return err
+// updatedProduct is the state of a product an update is checked against
+type updatedProduct struct {
+	stock, version, held int
+}
+
+// getUpdatedProduct reads the stock, version and held stock of a product
+// about to be updated in tx, failing with ErrProductNotFound
+func getUpdatedProduct(ctx context.Context, tx *sql.Tx, id int, now time.Time) (updatedProduct, error) {
+	var current updatedProduct
+	err := tx.QueryRowContext(ctx, `SELECT stock, version, `+heldStockSQL+` FROM products WHERE id = ? AND deleted_at IS NULL`,
+		now, id).Scan(&current.stock, &current.version, &current.held)
+	if err == sql.ErrNoRows {
+		return current, ErrProductNotFound
+	}
+	return current, err
+}
+
+// rejected tells why an update of the product to product was not applied:
+// either it was based on another version, or it lowered the stock below what
+// active reservations hold
+func (current updatedProduct) rejected(product *model.Product) error {
+	if product.Version != current.version && product.Version != AnyVersion {
+		return ErrProductVersionMismatch
+	}
+	available := current.stock - current.held
+	return &StockError{Operation: "product update", Lines: []model.StockLineError{{
+		Line: 1, ProductID: product.ID, Delta: product.Stock - current.stock, Available: &available, Error: "insufficient stock",
+	}}}
+}
+
+// productColumns reads the value of each product column UpdateProductFields
+// can change
+var productColumns = map[string]func(product *model.Product) any{
+	"name":        func(product *model.Product) any { return product.Name },
+	"description": func(product *model.Product) any { return product.Description },
+	"price":       func(product *model.Product) any { return product.Price },
+	"stock":       func(product *model.Product) any { return product.Stock },
+	"category_id": func(product *model.Product) any { return product.CategoryID },
+}
+
+// Update only the given columns of a product, taking their values from
+// product. Like UpdateProduct it only applies to the current version, bumps
+// it and records any change of stock.
+func (repo *ProductRepository) UpdateProductFields(ctx context.Context, product *model.Product, fields []string, actor string) (err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.UpdateProductFields", "UPDATE", "products")
+	defer func() { tracing.End(span, err) }()
+
+	assignments := []string{`version = version + 1`}
+	var args []any
+	for _, field := range fields {
+		value, ok := productColumns[field]
+		if !ok {
+			return fmt.Errorf("cannot update product column %q", field)
+		}
+		assignments = append(assignments, field+` = ?`)
+		args = append(args, value(product))
+	}
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	current, err := getUpdatedProduct(ctx, tx, product.ID, now)
+	if err != nil {
+		return err
+	}
+	stock := current.stock
+
+	// Lowering the stock may not eat into held stock, as in UpdateProduct
+	var version int
+	newStock := stock
+	if slices.Contains(fields, "stock") {
+		newStock = product.Stock
+	}
+	args = append(args, product.ID, product.Version, newStock, newStock, now)
+	err = tx.QueryRowContext(ctx, `UPDATE products SET `+strings.Join(assignments, `, `)+`
+		WHERE id = ? AND ? IN (version, 0) AND (? >= stock OR ? >= `+heldStockSQL+`) RETURNING version`, args...).Scan(&version)
+	if err == sql.ErrNoRows {
+		updated := *product
+		updated.Stock = newStock
+		return current.rejected(&updated)
+	}
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(fields, "stock") {
+		if delta := product.Stock - stock; delta != 0 {
+			if err := insertStockMovement(ctx, tx, product.ID, delta, product.Stock, model.ReasonAdjustment, actor); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	product.Version = version
+	return nil
+}
+
+// Delete a product, keeping it until it is purged so that it can be restored.
+// Deleting bumps the version.
+func (repo *ProductRepository) DeleteProduct(ctx context.Context, id int) (err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.DeleteProduct", "UPDATE", "products")
+	defer func() { tracing.End(span, err) }()
+
+	result, err := repo.db.ExecContext(ctx, `UPDATE products SET deleted_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+// Restore a deleted product, bumping its version. It fails with
+// ErrProductNotDeleted if the product is not deleted.
+func (repo *ProductRepository) RestoreProduct(ctx context.Context, id int) (err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.RestoreProduct", "UPDATE", "products")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var deletedAt *time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT deleted_at FROM products WHERE id = ?`, id).Scan(&deletedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrProductNotFound
+		}
+		return err
+	}
+	if deletedAt == nil {
+		return ErrProductNotDeleted
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE products SET deleted_at = NULL, version = version + 1 WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete for good the products deleted before a time, returning how many
+// were purged. Their stock ledgers are kept.
+func (repo *ProductRepository) PurgeDeletedProducts(ctx context.Context, before time.Time) (purged int, err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.PurgeDeletedProducts", "DELETE", "products")
+	defer func() { tracing.End(span, err) }()
+
+	result, err := repo.db.ExecContext(ctx, `DELETE FROM products WHERE deleted_at IS NOT NULL AND deleted_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// Get the products matching a filter with pagination, along with the
+// total number of matching products
+func (repo *ProductRepository) GetAllProducts(ctx context.Context, filter model.ProductFilter) (products []model.Product, total int, err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.GetAllProducts", "SELECT", "products")
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now().UTC()
+	conditions, args := repo.productConditions(filter, now)
+	where := whereClause(conditions)
+
+	if err := repo.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM products`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id, name, description, price, stock, stock - ` + heldStockSQL + `, category_id, version, deleted_at FROM products` +
+		where + productOrder(filter.Sort) + ` LIMIT ? OFFSET ?`
+	args = append([]any{now}, args...)
+	args = append(args, filter.Limit, (filter.Page-1)*filter.Limit)
+
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	products, err = scanProducts(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return products, total, nil
+}
+
+// Get up to filter.Limit products matching a filter that sort after cursor,
+// or from the start when cursor is nil. Unlike OFFSET paging this seeks
+// straight to the cursor, so every page costs the same.
+func (repo *ProductRepository) GetProductsAfter(ctx context.Context, filter model.ProductFilter, cursor *model.ProductCursor) (products []model.Product, err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.GetProductsAfter", "SELECT", "products")
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now().UTC()
+	conditions, args := repo.productConditions(filter, now)
+	if cursor != nil {
+		condition, cursorArgs := keysetCondition(model.StableSort(filter.Sort), cursor.Values)
+		conditions = append(conditions, condition)
+		args = append(args, cursorArgs...)
+	}
+
+	query := `SELECT id, name, description, price, stock, stock - ` + heldStockSQL + `, category_id, version, deleted_at FROM products` +
+		whereClause(conditions) + productOrder(filter.Sort) + ` LIMIT ?`
+	args = append([]any{now}, args...)
+	args = append(args, filter.Limit)
+
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanProducts(rows)
+}
+
+// Call fn with every product matching a filter in the order of the listing,
+// ignoring its page and limit. Rows are read one at a time as fn consumes
+// them, so the matching products are never all held in memory. The read sees
+// a snapshot and does not block writes, fn may even use the repository, but
+// the write-ahead log cannot be checkpointed while it lasts, so fn should not
+// stall. An error from fn stops the iteration and is returned.
+func (repo *ProductRepository) EachProduct(ctx context.Context, filter model.ProductFilter, fn func(model.Product) error) (err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.EachProduct", "SELECT", "products")
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now().UTC()
+	conditions, args := repo.productConditions(filter, now)
+	query := `SELECT id, name, description, price, stock, stock - ` + heldStockSQL + `, category_id, version, deleted_at FROM products` +
+		whereClause(conditions) + productOrder(filter.Sort)
+	args = append([]any{now}, args...)
+
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var product model.Product
+		if err := scanProduct(rows, &product); err != nil {
+			return err
+		}
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// keysetCondition matches the rows sorting after values, which holds one
+// value per sort key: (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+func keysetCondition(sort []model.SortKey, values []any) (string, []any) {
+	var alternatives []string
+	var args []any
+	for i, key := range sort {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, sort[j].Field+` = ?`)
+			args = append(args, values[j])
+		}
+		operator := ` > ?`
+		if key.Desc {
+			operator = ` < ?`
+		}
+		terms = append(terms, key.Field+operator)
+		args = append(args, values[i])
+		alternatives = append(alternatives, `(`+strings.Join(terms, ` AND `)+`)`)
+	}
+	return `(` + strings.Join(alternatives, ` OR `) + `)`, args
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return ` WHERE ` + strings.Join(conditions, ` AND `)
+}
+
+// productConditions builds the WHERE clause of a product listing
+func (repo *ProductRepository) productConditions(filter model.ProductFilter, now time.Time) ([]string, []any) {
+	var conditions []string
+	var args []any
+
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, `deleted_at IS NULL`)
+	}
+	if filter.Query != "" {
+		if repo.fullTextSearch {
+			conditions = append(conditions, `id IN (SELECT rowid FROM products_fts WHERE products_fts MATCH ?)`)
+			args = append(args, ftsQuery(filter.Query))
+		} else {
+			pattern := "%" + likeEscaper.Replace(filter.Query) + "%"
+			conditions = append(conditions, `(name LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\')`)
+			args = append(args, pattern, pattern)
+		}
+	}
+	if filter.MinPrice != nil {
+		conditions = append(conditions, `price >= ?`)
+		args = append(args, *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		conditions = append(conditions, `price <= ?`)
+		args = append(args, *filter.MaxPrice)
+	}
+	if filter.CategoryID != nil {
+		if filter.IncludeDescendants {
+			conditions = append(conditions, `category_id IN (WITH RECURSIVE tree(id) AS (
+					SELECT ?
+					UNION
+					SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+				)
+				SELECT id FROM tree)`)
+		} else {
+			conditions = append(conditions, `category_id = ?`)
+		}
+		args = append(args, *filter.CategoryID)
+	}
+	if filter.InStock != nil {
+		if *filter.InStock {
+			conditions = append(conditions, `stock - `+heldStockSQL+` > 0`)
+		} else {
+			conditions = append(conditions, `stock - `+heldStockSQL+` <= 0`)
+		}
+		args = append(args, now)
+	}
+	return conditions, args
+}
+
+// productOrder builds the ORDER BY clause of a product listing. The id is
+// always one of the keys so that the order is stable.
+func productOrder(sort []model.SortKey) string {
+	var keys []string
+	for _, key := range model.StableSort(sort) {
+		if !slices.Contains(model.ProductSortFields, key.Field) {
+			continue
+		}
+		if key.Desc {
+			keys = append(keys, key.Field+` DESC`)
+		} else {
+			keys = append(keys, key.Field+` ASC`)
+		}
+	}
+	return ` ORDER BY ` + strings.Join(keys, `, `)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ftsQuery turns free text into an FTS5 query matching every word as a prefix
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Adjust the stock of several products, applying every line or none of them.
+// Deductions may not eat into stock held by active reservations.
+func (repo *ProductRepository) AdjustStock(ctx context.Context, adjustments []model.StockAdjustment, actor string) (levels []model.StockLevel, err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.AdjustStock", "UPDATE", "products")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var lineErrors []model.StockLineError
+	for i, adjustment := range adjustments {
+		level := model.StockLevel{ProductID: adjustment.ProductID}
+		err := tx.QueryRowContext(ctx, `UPDATE products SET stock = stock + ?, version = version + 1
+			WHERE id = ? AND deleted_at IS NULL AND stock + ? >= 0 AND (? > 0 OR stock + ? >= `+heldStockSQL+`) RETURNING stock`,
+			adjustment.Delta, adjustment.ProductID, adjustment.Delta, adjustment.Delta, adjustment.Delta, now).Scan(&level.Stock)
+		if err == nil {
+			if err := insertStockMovement(ctx, tx, adjustment.ProductID, adjustment.Delta, level.Stock, adjustment.Reason, actor); err != nil {
+				return nil, err
+			}
+			levels = append(levels, level)
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		lineError := model.StockLineError{Line: i + 1, ProductID: adjustment.ProductID, Delta: adjustment.Delta}
+		var available int
+		switch err := tx.QueryRowContext(ctx, `SELECT stock - `+heldStockSQL+` FROM products WHERE id = ? AND deleted_at IS NULL`,
+			now, adjustment.ProductID).Scan(&available); err {
+		case nil:
+			lineError.Available = &available
+			lineError.Error = "insufficient stock"
+		case sql.ErrNoRows:
+			lineError.Error = "product not found"
+		default:
+			return nil, err
+		}
+		lineErrors = append(lineErrors, lineError)
+	}
+
+	if len(lineErrors) > 0 {
+		return nil, &StockError{Operation: "stock adjustment", Lines: lineErrors}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// Get the number of products, how many are out of stock and the value of
+// the stock on hand
+func (repo *ProductRepository) GetInventoryStats(ctx context.Context) (stats model.InventoryStats, err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.GetInventoryStats", "SELECT", "products")
+	defer func() { tracing.End(span, err) }()
+
+	err = repo.db.QueryRowContext(ctx, `SELECT COUNT(*),
+			COALESCE(SUM(stock - `+heldStockSQL+` <= 0), 0),
+			COALESCE(SUM(price * stock), 0)
+		FROM products WHERE deleted_at IS NULL`, time.Now().UTC()).Scan(&stats.Products, &stats.OutOfStock, &stats.Value)
+	return stats, err
 }
 
-// Get all products with pagination
-func (repo *ProductRepository) GetAllProducts(page, limit int) ([]model.Product, error) {
-	rows, err := repo.db.Query(`SELECT id, name, description, price, stock, category_id FROM products LIMIT ? OFFSET ?`,
-		limit, (page-1)*limit)
+// Get the stock ledger of a product, newest first
+func (repo *ProductRepository) GetStockMovements(ctx context.Context, productID int, filter model.StockMovementFilter) (movements []model.StockMovement, err error) {
+	ctx, span := startQuery(ctx, "ProductRepository.GetStockMovements", "SELECT", "stock_movements")
+	defer func() { tracing.End(span, err) }()
+
+	query := `SELECT id, product_id, delta, quantity, reason, actor, created_at FROM stock_movements WHERE product_id = ?`
+	args := []any{productID}
+	if filter.From != nil {
+		query += ` AND created_at >= ?`
+		args = append(args, filter.From.UTC())
+	}
+	if filter.To != nil {
+		query += ` AND created_at < ?`
+		args = append(args, filter.To.UTC())
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	args = append(args, filter.Limit, (filter.Page-1)*filter.Limit)
+
+	rows, err := repo.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var products []model.Product
+	movements = []model.StockMovement{}
+	for rows.Next() {
+		var movement model.StockMovement
+		if err := rows.Scan(&movement.ID, &movement.ProductID, &movement.Delta, &movement.Quantity,
+			&movement.Reason, &movement.Actor, &movement.CreatedAt); err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+	return movements, rows.Err()
+}
+
+// insertStockMovement records a change of stock in the ledger. It must run in
+// the same transaction as the change itself.
+func insertStockMovement(ctx context.Context, tx *sql.Tx, productID, delta, quantity int, reason, actor string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO stock_movements (product_id, delta, quantity, reason, actor, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, productID, delta, quantity, reason, actor, time.Now().UTC())
+	return err
+}
+
+// scanProducts reads every row of a product query with scanProduct
+func scanProducts(rows *sql.Rows) ([]model.Product, error) {
+	products := []model.Product{}
 	for rows.Next() {
 		var product model.Product
-		if err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.Stock, &product.CategoryID); err != nil {
+		if err := scanProduct(rows, &product); err != nil {
 			return nil, err
 		}
 		products = append(products, product)
 	}
-	return products, nil
+	return products, rows.Err()
+}
+
+// scanProduct reads a product selected with stock, available stock, version
+// and deletion time columns from a *sql.Row or the current row of *sql.Rows
+func scanProduct(row interface{ Scan(dest ...any) error }, product *model.Product) error {
+	return row.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.Stock, &product.Available,
+		&product.CategoryID, &product.Version, &product.DeletedAt)
 }
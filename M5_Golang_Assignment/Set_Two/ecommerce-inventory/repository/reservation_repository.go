@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"ecommerce-inventory/model"
+	"time"
+)
+
+var (
+	ErrReservationNotFound  = model.NewError(model.ErrNotFound, "reservation not found")
+	ErrReservationNotActive = model.NewError(model.ErrConflict, "reservation is no longer active")
+)
+
+type ReservationRepository struct {
+	db *sql.DB
+}
+
+func NewReservationRepository(db *sql.DB) *ReservationRepository {
+	return &ReservationRepository{db: db}
+}
+
+// Create a reservation, holding every item or none of them
+func (repo *ReservationRepository) CreateReservation(ctx context.Context, reservation *model.Reservation) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `INSERT INTO reservations (status, expires_at, created_at) VALUES (?, ?, ?)`,
+		reservation.Status, reservation.ExpiresAt.UTC(), reservation.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	// Each item is only inserted when the product has enough stock that is
+	// not already held by another active reservation.
+	now := time.Now().UTC()
+	var lineErrors []model.StockLineError
+	for i, item := range reservation.Items {
+		result, err := tx.ExecContext(ctx, `INSERT INTO reservation_items (reservation_id, product_id, quantity)
+			SELECT ?, id, ? FROM products WHERE id = ? AND deleted_at IS NULL AND stock - `+heldStockSQL+` >= ?`,
+			id, item.Quantity, item.ProductID, now, item.Quantity)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 1 {
+			continue
+		}
+
+		lineError := model.StockLineError{Line: i + 1, ProductID: item.ProductID, Delta: -item.Quantity}
+		var available int
+		switch err := tx.QueryRowContext(ctx, `SELECT stock - `+heldStockSQL+` FROM products WHERE id = ? AND deleted_at IS NULL`,
+			now, item.ProductID).Scan(&available); err {
+		case nil:
+			lineError.Available = &available
+			lineError.Error = "insufficient stock"
+		case sql.ErrNoRows:
+			lineError.Error = "product not found"
+		default:
+			return err
+		}
+		lineErrors = append(lineErrors, lineError)
+	}
+
+	if len(lineErrors) > 0 {
+		return &StockError{Operation: "reservation", Lines: lineErrors}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	reservation.ID = int(id)
+	return nil
+}
+
+// Get a reservation and its items by ID
+func (repo *ReservationRepository) GetReservationByID(ctx context.Context, id int) (*model.Reservation, error) {
+	return getReservation(ctx, repo.db, id)
+}
+
+// Commit a reservation, deducting its items from stock as a sale. Items of
+// products deleted since they were reserved fail the commit.
+func (repo *ReservationRepository) CommitReservation(ctx context.Context, id int, actor string) (*model.Reservation, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setReservationStatus(ctx, tx, id, model.ReservationCommitted); err != nil {
+		return nil, err
+	}
+	reservation, err := getReservation(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var lineErrors []model.StockLineError
+	for i, item := range reservation.Items {
+		var stock int
+		err := tx.QueryRowContext(ctx, `UPDATE products SET stock = stock - ?, version = version + 1
+			WHERE id = ? AND deleted_at IS NULL AND stock - ? >= 0 RETURNING stock`,
+			item.Quantity, item.ProductID, item.Quantity).Scan(&stock)
+		if err == nil {
+			if err := insertStockMovement(ctx, tx, item.ProductID, -item.Quantity, stock, model.ReasonSale, actor); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		lineError := model.StockLineError{Line: i + 1, ProductID: item.ProductID, Delta: -item.Quantity, Error: "insufficient stock"}
+		var found bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = ? AND deleted_at IS NULL)`, item.ProductID).Scan(&found); err != nil {
+			return nil, err
+		}
+		if !found {
+			lineError.Error = "product not found"
+		}
+		lineErrors = append(lineErrors, lineError)
+	}
+
+	if len(lineErrors) > 0 {
+		return nil, &StockError{Operation: "reservation commit", Lines: lineErrors}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// Release a reservation, returning its held stock
+func (repo *ReservationRepository) ReleaseReservation(ctx context.Context, id int) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setReservationStatus(ctx, tx, id, model.ReservationReleased); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Mark active reservations that expired before now as expired
+func (repo *ReservationRepository) ExpireReservations(ctx context.Context, now time.Time) (int64, error) {
+	result, err := repo.db.ExecContext(ctx, `UPDATE reservations SET status = ? WHERE status = ? AND expires_at <= ?`,
+		model.ReservationExpired, model.ReservationActive, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getReservation(ctx context.Context, q queryer, id int) (*model.Reservation, error) {
+	reservation := &model.Reservation{}
+	row := q.QueryRowContext(ctx, `SELECT id, status, expires_at, created_at FROM reservations WHERE id = ?`, id)
+	if err := row.Scan(&reservation.ID, &reservation.Status, &reservation.ExpiresAt, &reservation.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrReservationNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT product_id, quantity FROM reservation_items WHERE reservation_id = ? ORDER BY product_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item model.ReservationItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity); err != nil {
+			return nil, err
+		}
+		reservation.Items = append(reservation.Items, item)
+	}
+	return reservation, rows.Err()
+}
+
+// setReservationStatus moves an active, unexpired reservation to status
+func setReservationStatus(ctx context.Context, tx *sql.Tx, id int, status string) error {
+	result, err := tx.ExecContext(ctx, `UPDATE reservations SET status = ? WHERE id = ? AND status = ? AND expires_at > ?`,
+		status, id, model.ReservationActive, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 1 {
+		return nil
+	}
+
+	if _, err := getReservation(ctx, tx, id); err != nil {
+		return err
+	}
+	return ErrReservationNotActive
+}
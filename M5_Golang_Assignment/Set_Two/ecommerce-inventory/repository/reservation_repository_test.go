@@ -0,0 +1,118 @@
+package repository_test
+
+import (
+	"context"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommitReservationOfDeletedProduct(t *testing.T) {
+	db := openDatabase(t)
+	products := repository.NewProductRepository(db)
+	reservations := repository.NewReservationRepository(db)
+
+	lamp := model.Product{Name: "Lamp", Price: 20, Stock: 5}
+	chair := model.Product{Name: "Chair", Price: 45, Stock: 5}
+	for _, product := range []*model.Product{&lamp, &chair} {
+		if err := products.AddProduct(context.Background(), product, "tester"); err != nil {
+			t.Fatalf("AddProduct: %v", err)
+		}
+	}
+	now := time.Now()
+	reservation := model.Reservation{
+		Status:    model.ReservationActive,
+		Items:     []model.ReservationItem{{ProductID: lamp.ID, Quantity: 2}, {ProductID: chair.ID, Quantity: 1}},
+		ExpiresAt: now.Add(time.Hour),
+		CreatedAt: now,
+	}
+	if err := reservations.CreateReservation(context.Background(), &reservation); err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+	if err := products.DeleteProduct(context.Background(), chair.ID); err != nil {
+		t.Fatalf("DeleteProduct: %v", err)
+	}
+
+	_, err := reservations.CommitReservation(context.Background(), reservation.ID, "tester")
+	var stockErr *repository.StockError
+	if !errors.As(err, &stockErr) || len(stockErr.Lines) != 1 ||
+		stockErr.Lines[0].Line != 2 || stockErr.Lines[0].Error != "product not found" {
+		t.Fatalf("CommitReservation with a deleted product: got %v, want product not found on line 2", err)
+	}
+
+	// Nothing of the failed commit is applied
+	got, err := products.GetProductByID(context.Background(), lamp.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if got.Stock != 5 || got.Version != 1 {
+		t.Errorf("after a failed commit the lamp has stock %d at version %d, want 5 at version 1", got.Stock, got.Version)
+	}
+	if err := products.RestoreProduct(context.Background(), chair.ID); err != nil {
+		t.Fatalf("RestoreProduct: %v", err)
+	}
+	restored, err := products.GetProductByID(context.Background(), chair.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if restored.Stock != 5 {
+		t.Errorf("the deleted chair has stock %d after a failed commit, want 5", restored.Stock)
+	}
+}
+
+func TestUpdateBelowHeldStock(t *testing.T) {
+	db := openDatabase(t)
+	products := repository.NewProductRepository(db)
+	lamp := model.Product{Name: "Lamp", Price: 20, Stock: 5}
+	if err := products.AddProduct(context.Background(), &lamp, "tester"); err != nil {
+		t.Fatalf("AddProduct: %v", err)
+	}
+	now := time.Now()
+	if err := repository.NewReservationRepository(db).CreateReservation(context.Background(), &model.Reservation{
+		Status:    model.ReservationActive,
+		Items:     []model.ReservationItem{{ProductID: lamp.ID, Quantity: 3}},
+		ExpiresAt: now.Add(time.Hour),
+		CreatedAt: now,
+	}); err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+
+	wantStockError := func(operation string, err error) {
+		t.Helper()
+		var stockErr *repository.StockError
+		if !errors.As(err, &stockErr) || len(stockErr.Lines) != 1 || stockErr.Lines[0].Available == nil ||
+			*stockErr.Lines[0].Available != 2 || stockErr.Lines[0].Error != "insufficient stock" {
+			t.Errorf("%s below the held stock: got %v, want insufficient stock with 2 available", operation, err)
+		}
+	}
+	low := lamp
+	low.Stock = 2
+	wantStockError("UpdateProduct", products.UpdateProduct(context.Background(), &low, "tester"))
+	wantStockError("UpdateProductFields", products.UpdateProductFields(context.Background(), &low, []string{"stock"}, "tester"))
+
+	// A stale version is reported as such, whatever the stock
+	low.Version = 7
+	if err := products.UpdateProduct(context.Background(), &low, "tester"); !errors.Is(err, repository.ErrProductVersionMismatch) {
+		t.Errorf("UpdateProduct of a stale version: got %v, want ErrProductVersionMismatch", err)
+	}
+
+	// Other fields can still change, and the stock can go down to what is held
+	rename := lamp
+	rename.Name = "Desk lamp"
+	if err := products.UpdateProductFields(context.Background(), &rename, []string{"name"}, "tester"); err != nil {
+		t.Fatalf("UpdateProductFields of the name: %v", err)
+	}
+	rename.Stock = 3
+	if err := products.UpdateProduct(context.Background(), &rename, "tester"); err != nil {
+		t.Fatalf("UpdateProduct down to the held stock: %v", err)
+	}
+	got, err := products.GetProductByID(context.Background(), lamp.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if got.Name != "Desk lamp" || got.Stock != 3 || got.Available != 0 {
+		t.Errorf("after the updates got %+v, want the desk lamp with 3 in stock and none available", *got)
+	}
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"ecommerce-inventory/model"
+	"time"
+)
+
+// ProductStore keeps products and their stock ledger. ProductRepository is
+// the SQLite implementation, memory.ProductStore keeps everything in memory.
+// Both behave the same, which the storetest package checks.
+//
+// Deleted products are kept until they are purged, but only listings with
+// IncludeDeleted set and RestoreProduct see them.
+type ProductStore interface {
+	// Add a product, setting its ID and version, and record its opening stock
+	AddProduct(ctx context.Context, product *model.Product, actor string) error
+	// Add several products like AddProduct, either all of them or none
+	AddProducts(ctx context.Context, products []model.Product, actor string) error
+	// Get a product by ID, failing with ErrProductNotFound
+	GetProductByID(ctx context.Context, id int) (*model.Product, error)
+	// Update a product and record any change of stock. It fails with
+	// ErrProductNotFound, or ErrProductVersionMismatch unless product.Version
+	// is the current version or AnyVersion, or with a *StockError when it
+	// lowers the stock below what active reservations hold, and sets
+	// product.Version to the new one.
+	UpdateProduct(ctx context.Context, product *model.Product, actor string) error
+	// Update only the named fields of a product, which are its JSON field
+	// names, otherwise behaving like UpdateProduct
+	UpdateProductFields(ctx context.Context, product *model.Product, fields []string, actor string) error
+	// Delete a product, bumping its version, failing with ErrProductNotFound
+	DeleteProduct(ctx context.Context, id int) error
+	// Restore a deleted product, bumping its version, failing with
+	// ErrProductNotFound or ErrProductNotDeleted
+	RestoreProduct(ctx context.Context, id int) error
+	// Delete for good the products deleted before a time, returning how many there were
+	PurgeDeletedProducts(ctx context.Context, before time.Time) (int, error)
+	// Get a page of the products matching a filter and their total number
+	GetAllProducts(ctx context.Context, filter model.ProductFilter) ([]model.Product, int, error)
+	// Get up to filter.Limit products sorting after cursor, or from the start when it is nil
+	GetProductsAfter(ctx context.Context, filter model.ProductFilter, cursor *model.ProductCursor) ([]model.Product, error)
+	// Call fn with every product matching a filter in listing order, ignoring paging, until fn fails
+	EachProduct(ctx context.Context, filter model.ProductFilter, fn func(model.Product) error) error
+	// Adjust the stock of several products, bumping their versions, applying every line or failing with a *StockError
+	AdjustStock(ctx context.Context, adjustments []model.StockAdjustment, actor string) ([]model.StockLevel, error)
+	// Get the number of products, how many are out of stock and the value of the stock on hand
+	GetInventoryStats(ctx context.Context) (model.InventoryStats, error)
+	// Get a page of the stock ledger of a product, newest first
+	GetStockMovements(ctx context.Context, productID int, filter model.StockMovementFilter) ([]model.StockMovement, error)
+}
+
+// UserStore keeps user accounts. UserRepository is the SQLite
+// implementation, memory.UserStore keeps them in memory.
+type UserStore interface {
+	// Get a user by username, failing with ErrUserNotFound
+	GetUserByUsername(username string) (*model.User, error)
+	// Get a user by ID, failing with ErrUserNotFound
+	GetUserByID(id int) (*model.User, error)
+	// Register a user, setting its ID and role, failing with ErrUsernameTaken
+	RegisterUser(user *model.User) error
+	// Replace the password hash of a user, failing with ErrUserNotFound
+	UpdatePassword(id int, password string) error
+	// Change the role of a user, failing with ErrUserNotFound
+	UpdateRole(id int, role string) error
+}
+
+var (
+	_ ProductStore = (*ProductRepository)(nil)
+	_ UserStore    = (*UserRepository)(nil)
+)
@@ -0,0 +1,60 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"ecommerce-inventory/config"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"ecommerce-inventory/repository/storetest"
+	"path/filepath"
+	"testing"
+)
+
+// openDatabase creates a migrated database, search index included, in a
+// temporary directory
+func openDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := config.InitializeDatabase(filepath.Join(t.TempDir(), "test.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestProductRepository(t *testing.T) {
+	storetest.RunProductStoreTests(t, func(t *testing.T) repository.ProductStore {
+		return repository.NewProductRepository(openDatabase(t))
+	})
+}
+
+func TestUserRepository(t *testing.T) {
+	storetest.RunUserStoreTests(t, func(t *testing.T) repository.UserStore {
+		return repository.NewUserRepository(openDatabase(t))
+	})
+}
+
+// A long export reads products while others keep writing them
+func TestEachProductDoesNotBlockWrites(t *testing.T) {
+	repo := repository.NewProductRepository(openDatabase(t))
+	for _, name := range []string{"Lamp", "Chair", "Desk"} {
+		product := model.Product{Name: name, Price: 10, Stock: 1}
+		if err := repo.AddProduct(context.Background(), &product, "tester"); err != nil {
+			t.Fatalf("AddProduct: %v", err)
+		}
+	}
+
+	var names []string
+	err := repo.EachProduct(context.Background(), model.ProductFilter{}, func(product model.Product) error {
+		names = append(names, product.Name)
+		product.Name += " (exported)"
+		return repo.UpdateProduct(context.Background(), &product, "tester")
+	})
+	if err != nil {
+		t.Fatalf("updating products during EachProduct: %v", err)
+	}
+	if len(names) != 3 || names[0] != "Lamp" {
+		t.Errorf("EachProduct saw %v, want the three products as they were", names)
+	}
+}
@@ -0,0 +1,884 @@
+// Package storetest checks that an implementation of the repository store
+// interfaces behaves like the others. Each backend runs the same suite from
+// its own tests.
+package storetest
+
+import (
+	"context"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"errors"
+	"testing"
+	"time"
+)
+
+// RunProductStoreTests runs the ProductStore suite. newStore must return an
+// empty store for every call.
+func RunProductStoreTests(t *testing.T, newStore func(t *testing.T) repository.ProductStore) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store repository.ProductStore)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"AddProducts", testAddProducts},
+		{"GetNotFound", testGetNotFound},
+		{"Update", testUpdate},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"UpdateStaleVersion", testUpdateStaleVersion},
+		{"UpdateAnyVersion", testUpdateAnyVersion},
+		{"UpdateFields", testUpdateFields},
+		{"UpdateFieldsErrors", testUpdateFieldsErrors},
+		{"Delete", testDelete},
+		{"DeleteNotFound", testDeleteNotFound},
+		{"DeletedProductsAreHidden", testDeletedProductsAreHidden},
+		{"Restore", testRestore},
+		{"Purge", testPurge},
+		{"Filter", testFilter},
+		{"Search", testSearch},
+		{"Sort", testSort},
+		{"Paging", testPaging},
+		{"Cursor", testCursor},
+		{"EachProduct", testEachProduct},
+		{"AdjustStock", testAdjustStock},
+		{"AdjustStockAllOrNothing", testAdjustStockAllOrNothing},
+		{"StockMovements", testStockMovements},
+		{"InventoryStats", testInventoryStats},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newStore(t))
+		})
+	}
+}
+
+// RunUserStoreTests runs the UserStore suite. newStore must return an empty
+// store for every call.
+func RunUserStoreTests(t *testing.T, newStore func(t *testing.T) repository.UserStore) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store repository.UserStore)
+	}{
+		{"RegisterAndGet", testRegisterAndGet},
+		{"RegisterKeepsRole", testRegisterKeepsRole},
+		{"DuplicateUsername", testDuplicateUsername},
+		{"UserNotFound", testUserNotFound},
+		{"UpdatePassword", testUpdatePassword},
+		{"UpdateRole", testUpdateRole},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newStore(t))
+		})
+	}
+}
+
+// seedProducts adds a small catalogue to store and returns it with IDs set
+func seedProducts(t *testing.T, store repository.ProductStore) []model.Product {
+	t.Helper()
+	products := []model.Product{
+		{Name: "Laptop", Description: "A light laptop", Price: 999.5, Stock: 5, CategoryID: 1},
+		{Name: "Mouse", Description: "Wireless mouse", Price: 25, Stock: 0, CategoryID: 2},
+		{Name: "Keyboard", Description: "Mechanical keyboard for a laptop", Price: 80, Stock: 12, CategoryID: 2},
+		{Name: "Monitor", Description: "27 inch display", Price: 300, Stock: 3, CategoryID: 1},
+	}
+	for i := range products {
+		if err := store.AddProduct(context.Background(), &products[i], "tester"); err != nil {
+			t.Fatalf("AddProduct(%q): %v", products[i].Name, err)
+		}
+		products[i].Available = products[i].Stock
+	}
+	return products
+}
+
+func listFilter() model.ProductFilter {
+	return model.ProductFilter{Page: 1, Limit: 100}
+}
+
+func names(products []model.Product) []string {
+	names := []string{}
+	for _, product := range products {
+		names = append(names, product.Name)
+	}
+	return names
+}
+
+func equalNames(t *testing.T, got []model.Product, want ...string) {
+	t.Helper()
+	gotNames := names(got)
+	if len(gotNames) != len(want) {
+		t.Fatalf("got %v, want %v", gotNames, want)
+	}
+	for i := range want {
+		if gotNames[i] != want[i] {
+			t.Fatalf("got %v, want %v", gotNames, want)
+		}
+	}
+}
+
+func testAddAndGet(t *testing.T, store repository.ProductStore) {
+	products := seedProducts(t, store)
+	seen := map[int]bool{}
+	for _, want := range products {
+		if want.ID == 0 || seen[want.ID] {
+			t.Fatalf("AddProduct gave %q the ID %d", want.Name, want.ID)
+		}
+		seen[want.ID] = true
+
+		got, err := store.GetProductByID(context.Background(), want.ID)
+		if err != nil {
+			t.Fatalf("GetProductByID(%d): %v", want.ID, err)
+		}
+		if *got != want {
+			t.Errorf("GetProductByID(%d) = %+v, want %+v", want.ID, *got, want)
+		}
+	}
+}
+
+func testAddProducts(t *testing.T, store repository.ProductStore) {
+	products := []model.Product{
+		{Name: "Desk", Description: "Standing desk", Price: 450, Stock: 2, CategoryID: 3},
+		{Name: "Chair", Description: "Office chair", Price: 120, Stock: 0, CategoryID: 3},
+	}
+	if err := store.AddProducts(context.Background(), products, "importer"); err != nil {
+		t.Fatalf("AddProducts: %v", err)
+	}
+	if products[0].ID == 0 || products[0].ID == products[1].ID {
+		t.Fatalf("AddProducts gave the IDs %d and %d", products[0].ID, products[1].ID)
+	}
+	for _, want := range products {
+		got, err := store.GetProductByID(context.Background(), want.ID)
+		if err != nil {
+			t.Fatalf("GetProductByID(%d): %v", want.ID, err)
+		}
+		want.Available = want.Stock
+		if want.Version != 1 || *got != want {
+			t.Errorf("GetProductByID(%d) = %+v, want %+v at version 1", want.ID, *got, want)
+		}
+	}
+
+	movements, err := store.GetStockMovements(context.Background(), products[0].ID, model.StockMovementFilter{Page: 1, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetStockMovements: %v", err)
+	}
+	if len(movements) != 1 || movements[0].Delta != 2 || movements[0].Reason != model.ReasonRestock || movements[0].Actor != "importer" {
+		t.Errorf("movements after AddProducts = %+v, want the opening stock", movements)
+	}
+}
+
+func testGetNotFound(t *testing.T, store repository.ProductStore) {
+	_, err := store.GetProductByID(context.Background(), 42)
+	if !errors.Is(err, repository.ErrProductNotFound) || !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("GetProductByID of a missing product: got %v, want ErrProductNotFound", err)
+	}
+}
+
+func testUpdate(t *testing.T, store repository.ProductStore) {
+	product := seedProducts(t, store)[0]
+	product.Name = "Gaming laptop"
+	product.Price = 1499
+	product.Stock = 8
+	if err := store.UpdateProduct(context.Background(), &product, "tester"); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+
+	got, err := store.GetProductByID(context.Background(), product.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if product.Version != 2 {
+		t.Errorf("version after UpdateProduct = %d, want 2", product.Version)
+	}
+	product.Available = product.Stock
+	if *got != product {
+		t.Errorf("after UpdateProduct got %+v, want %+v", *got, product)
+	}
+}
+
+func testUpdateStaleVersion(t *testing.T, store repository.ProductStore) {
+	products := seedProducts(t, store)
+	first, second := products[0], products[0]
+	first.Name = "First edit"
+	if err := store.UpdateProduct(context.Background(), &first, "alice"); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	second.Name = "Second edit"
+	err := store.UpdateProduct(context.Background(), &second, "bob")
+	if !errors.Is(err, repository.ErrProductVersionMismatch) || !errors.Is(err, model.ErrPreconditionFailed) {
+		t.Fatalf("UpdateProduct of a stale version: got %v, want ErrProductVersionMismatch", err)
+	}
+
+	got, err := store.GetProductByID(context.Background(), first.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if got.Name != "First edit" || got.Version != 2 {
+		t.Errorf("after a stale update got %q at version %d, want the first edit at version 2", got.Name, got.Version)
+	}
+
+	// Stock adjustments change the product too
+	if _, err := store.AdjustStock(context.Background(), []model.StockAdjustment{
+		{ProductID: first.ID, Delta: 1, Reason: model.ReasonRestock},
+	}, "alice"); err != nil {
+		t.Fatalf("AdjustStock: %v", err)
+	}
+	if err := store.UpdateProduct(context.Background(), &first, "alice"); !errors.Is(err, repository.ErrProductVersionMismatch) {
+		t.Errorf("UpdateProduct after a stock adjustment: got %v, want ErrProductVersionMismatch", err)
+	}
+}
+
+func testUpdateAnyVersion(t *testing.T, store repository.ProductStore) {
+	product := seedProducts(t, store)[0]
+	if _, err := store.AdjustStock(context.Background(), []model.StockAdjustment{
+		{ProductID: product.ID, Delta: 1, Reason: model.ReasonRestock},
+	}, "alice"); err != nil {
+		t.Fatalf("AdjustStock: %v", err)
+	}
+
+	product.Name = "Any edit"
+	product.Version = repository.AnyVersion
+	if err := store.UpdateProduct(context.Background(), &product, "bob"); err != nil {
+		t.Fatalf("UpdateProduct of any version: %v", err)
+	}
+	if product.Version != 3 {
+		t.Errorf("version after UpdateProduct of any version = %d, want 3", product.Version)
+	}
+
+	change := model.Product{ID: product.ID, Name: "Any field edit", Version: repository.AnyVersion}
+	if err := store.UpdateProductFields(context.Background(), &change, []string{"name"}, "bob"); err != nil {
+		t.Fatalf("UpdateProductFields of any version: %v", err)
+	}
+	got, err := store.GetProductByID(context.Background(), product.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if got.Name != "Any field edit" || got.Version != 4 || change.Version != 4 {
+		t.Errorf("after updates of any version got %q at version %d, want the field edit at version 4", got.Name, got.Version)
+	}
+}
+
+func testUpdateNotFound(t *testing.T, store repository.ProductStore) {
+	product := model.Product{ID: 42, Name: "Ghost", Price: 1}
+	if err := store.UpdateProduct(context.Background(), &product, "tester"); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("UpdateProduct of a missing product: got %v, want ErrProductNotFound", err)
+	}
+}
+
+func testUpdateFields(t *testing.T, store repository.ProductStore) {
+	original := seedProducts(t, store)[0]
+	change := original
+	change.Name = "Renamed"
+	change.Description = "Not stored"
+	change.Stock = 7
+	if err := store.UpdateProductFields(context.Background(), &change, []string{"name", "stock"}, "tester"); err != nil {
+		t.Fatalf("UpdateProductFields: %v", err)
+	}
+	if change.Version != 2 {
+		t.Errorf("version after UpdateProductFields = %d, want 2", change.Version)
+	}
+
+	got, err := store.GetProductByID(context.Background(), original.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	want := original
+	want.Name, want.Stock, want.Available, want.Version = "Renamed", 7, 7, 2
+	if *got != want {
+		t.Errorf("after UpdateProductFields got %+v, want %+v", *got, want)
+	}
+
+	movements, err := store.GetStockMovements(context.Background(), original.ID, model.StockMovementFilter{Page: 1, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetStockMovements: %v", err)
+	}
+	if len(movements) != 2 || movements[0].Delta != 2 || movements[0].Reason != model.ReasonAdjustment {
+		t.Errorf("movements after UpdateProductFields = %+v, want an adjustment of 2 first", movements)
+	}
+}
+
+func testUpdateFieldsErrors(t *testing.T, store repository.ProductStore) {
+	product := seedProducts(t, store)[0]
+
+	missing := model.Product{ID: 42, Version: 1}
+	if err := store.UpdateProductFields(context.Background(), &missing, []string{"name"}, "tester"); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("UpdateProductFields of a missing product: got %v, want ErrProductNotFound", err)
+	}
+
+	stale := product
+	stale.Version = 3
+	if err := store.UpdateProductFields(context.Background(), &stale, []string{"name"}, "tester"); !errors.Is(err, repository.ErrProductVersionMismatch) {
+		t.Errorf("UpdateProductFields of a stale version: got %v, want ErrProductVersionMismatch", err)
+	}
+
+	if err := store.UpdateProductFields(context.Background(), &product, []string{"version"}, "tester"); err == nil {
+		t.Errorf("UpdateProductFields of the version column succeeded")
+	}
+	got, err := store.GetProductByID(context.Background(), product.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if *got != product {
+		t.Errorf("failed updates changed the product to %+v, want %+v", *got, product)
+	}
+}
+
+func testDelete(t *testing.T, store repository.ProductStore) {
+	products := seedProducts(t, store)
+	if err := store.DeleteProduct(context.Background(), products[1].ID); err != nil {
+		t.Fatalf("DeleteProduct: %v", err)
+	}
+	if _, err := store.GetProductByID(context.Background(), products[1].ID); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("GetProductByID after DeleteProduct: got %v, want ErrProductNotFound", err)
+	}
+
+	got, total, err := store.GetAllProducts(context.Background(), listFilter())
+	if err != nil {
+		t.Fatalf("GetAllProducts: %v", err)
+	}
+	if total != 3 {
+		t.Errorf("total after DeleteProduct = %d, want 3", total)
+	}
+	equalNames(t, got, "Laptop", "Keyboard", "Monitor")
+}
+
+func testDeleteNotFound(t *testing.T, store repository.ProductStore) {
+	if err := store.DeleteProduct(context.Background(), 42); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("DeleteProduct of a missing product: got %v, want ErrProductNotFound", err)
+	}
+
+	product := seedProducts(t, store)[0]
+	if err := store.DeleteProduct(context.Background(), product.ID); err != nil {
+		t.Fatalf("DeleteProduct: %v", err)
+	}
+	if err := store.DeleteProduct(context.Background(), product.ID); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("DeleteProduct of a deleted product: got %v, want ErrProductNotFound", err)
+	}
+}
+
+func testDeletedProductsAreHidden(t *testing.T, store repository.ProductStore) {
+	products := seedProducts(t, store)
+	deleted := products[0]
+	if err := store.DeleteProduct(context.Background(), deleted.ID); err != nil {
+		t.Fatalf("DeleteProduct: %v", err)
+	}
+
+	filter := listFilter()
+	filter.IncludeDeleted = true
+	got, total, err := store.GetAllProducts(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("GetAllProducts including deleted products: %v", err)
+	}
+	if total != 4 {
+		t.Errorf("total including deleted products = %d, want 4", total)
+	}
+	equalNames(t, got, "Laptop", "Mouse", "Keyboard", "Monitor")
+	if got[0].DeletedAt == nil || got[0].Version != 2 || got[1].DeletedAt != nil {
+		t.Errorf("listed the deleted product at version %d deleted at %v, want version 2 and a deletion time",
+			got[0].Version, got[0].DeletedAt)
+	}
+
+	deleted.Name = "Edited"
+	if err := store.UpdateProduct(context.Background(), &deleted, "tester"); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("UpdateProduct of a deleted product: got %v, want ErrProductNotFound", err)
+	}
+	if err := store.UpdateProductFields(context.Background(), &deleted, []string{"name"}, "tester"); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("UpdateProductFields of a deleted product: got %v, want ErrProductNotFound", err)
+	}
+	_, err = store.AdjustStock(context.Background(), []model.StockAdjustment{
+		{ProductID: deleted.ID, Delta: 1, Reason: model.ReasonRestock},
+	}, "tester")
+	var stockErr *repository.StockError
+	if !errors.As(err, &stockErr) || stockErr.Lines[0].Error != "product not found" {
+		t.Errorf("AdjustStock of a deleted product: got %v, want product not found", err)
+	}
+
+	var exported []model.Product
+	if err := store.EachProduct(context.Background(), listFilter(), func(product model.Product) error {
+		exported = append(exported, product)
+		return nil
+	}); err != nil {
+		t.Fatalf("EachProduct: %v", err)
+	}
+	equalNames(t, exported, "Mouse", "Keyboard", "Monitor")
+
+	stats, err := store.GetInventoryStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetInventoryStats: %v", err)
+	}
+	if want := (model.InventoryStats{Products: 3, OutOfStock: 1, Value: 80*12 + 300*3}); stats != want {
+		t.Errorf("GetInventoryStats = %+v, want %+v", stats, want)
+	}
+}
+
+func testRestore(t *testing.T, store repository.ProductStore) {
+	product := seedProducts(t, store)[0]
+	if err := store.RestoreProduct(context.Background(), product.ID); !errors.Is(err, repository.ErrProductNotDeleted) || !errors.Is(err, model.ErrConflict) {
+		t.Errorf("RestoreProduct of a product that is not deleted: got %v, want ErrProductNotDeleted", err)
+	}
+	if err := store.RestoreProduct(context.Background(), 42); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("RestoreProduct of a missing product: got %v, want ErrProductNotFound", err)
+	}
+
+	if err := store.DeleteProduct(context.Background(), product.ID); err != nil {
+		t.Fatalf("DeleteProduct: %v", err)
+	}
+	if err := store.RestoreProduct(context.Background(), product.ID); err != nil {
+		t.Fatalf("RestoreProduct: %v", err)
+	}
+	got, err := store.GetProductByID(context.Background(), product.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID after RestoreProduct: %v", err)
+	}
+	product.Version = 3
+	if *got != product {
+		t.Errorf("after RestoreProduct got %+v, want %+v", *got, product)
+	}
+}
+
+func testPurge(t *testing.T, store repository.ProductStore) {
+	products := seedProducts(t, store)
+	for _, product := range products[:2] {
+		if err := store.DeleteProduct(context.Background(), product.ID); err != nil {
+			t.Fatalf("DeleteProduct: %v", err)
+		}
+	}
+
+	purged, err := store.PurgeDeletedProducts(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("PurgeDeletedProducts: %v", err)
+	}
+	if purged != 0 {
+		t.Errorf("purged %d products deleted after the cutoff, want 0", purged)
+	}
+
+	purged, err = store.PurgeDeletedProducts(context.Background(), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("PurgeDeletedProducts: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("purged %d products, want 2", purged)
+	}
+	if err := store.RestoreProduct(context.Background(), products[0].ID); !errors.Is(err, repository.ErrProductNotFound) {
+		t.Errorf("RestoreProduct of a purged product: got %v, want ErrProductNotFound", err)
+	}
+	filter := listFilter()
+	filter.IncludeDeleted = true
+	got, _, err := store.GetAllProducts(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("GetAllProducts: %v", err)
+	}
+	equalNames(t, got, "Keyboard", "Monitor")
+}
+
+func testFilter(t *testing.T, store repository.ProductStore) {
+	seedProducts(t, store)
+	minPrice, maxPrice := 50.0, 500.0
+	category := 2
+	inStock, outOfStock := true, false
+
+	tests := []struct {
+		name   string
+		change func(filter *model.ProductFilter)
+		want   []string
+	}{
+		{"price range", func(filter *model.ProductFilter) {
+			filter.MinPrice, filter.MaxPrice = &minPrice, &maxPrice
+		}, []string{"Keyboard", "Monitor"}},
+		{"category", func(filter *model.ProductFilter) { filter.CategoryID = &category }, []string{"Mouse", "Keyboard"}},
+		{"in stock", func(filter *model.ProductFilter) { filter.InStock = &inStock }, []string{"Laptop", "Keyboard", "Monitor"}},
+		{"out of stock", func(filter *model.ProductFilter) { filter.InStock = &outOfStock }, []string{"Mouse"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := listFilter()
+			test.change(&filter)
+			got, total, err := store.GetAllProducts(context.Background(), filter)
+			if err != nil {
+				t.Fatalf("GetAllProducts: %v", err)
+			}
+			if total != len(test.want) {
+				t.Errorf("total = %d, want %d", total, len(test.want))
+			}
+			equalNames(t, got, test.want...)
+		})
+	}
+}
+
+func testSearch(t *testing.T, store repository.ProductStore) {
+	seedProducts(t, store)
+	filter := listFilter()
+	filter.Query = "lap"
+	got, total, err := store.GetAllProducts(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("GetAllProducts: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("total = %d, want 2", total)
+	}
+	equalNames(t, got, "Laptop", "Keyboard")
+}
+
+func testSort(t *testing.T, store repository.ProductStore) {
+	seedProducts(t, store)
+	tests := []struct {
+		sort []model.SortKey
+		want []string
+	}{
+		{nil, []string{"Laptop", "Mouse", "Keyboard", "Monitor"}},
+		{[]model.SortKey{{Field: "name"}}, []string{"Keyboard", "Laptop", "Monitor", "Mouse"}},
+		{[]model.SortKey{{Field: "price", Desc: true}}, []string{"Laptop", "Monitor", "Keyboard", "Mouse"}},
+		{[]model.SortKey{{Field: "category_id"}, {Field: "stock", Desc: true}}, []string{"Laptop", "Monitor", "Keyboard", "Mouse"}},
+	}
+	for _, test := range tests {
+		t.Run(model.FormatSort(test.sort), func(t *testing.T) {
+			filter := listFilter()
+			filter.Sort = test.sort
+			got, _, err := store.GetAllProducts(context.Background(), filter)
+			if err != nil {
+				t.Fatalf("GetAllProducts: %v", err)
+			}
+			equalNames(t, got, test.want...)
+		})
+	}
+}
+
+func testPaging(t *testing.T, store repository.ProductStore) {
+	seedProducts(t, store)
+	filter := model.ProductFilter{Page: 2, Limit: 3}
+	got, total, err := store.GetAllProducts(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("GetAllProducts: %v", err)
+	}
+	if total != 4 {
+		t.Errorf("total = %d, want 4", total)
+	}
+	equalNames(t, got, "Monitor")
+
+	filter.Page = 3
+	got, total, err = store.GetAllProducts(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("GetAllProducts past the end: %v", err)
+	}
+	if total != 4 || len(got) != 0 {
+		t.Errorf("past the end got %v of %d, want nothing of 4", names(got), total)
+	}
+}
+
+func testCursor(t *testing.T, store repository.ProductStore) {
+	seedProducts(t, store)
+	filter := model.ProductFilter{Limit: 2, Sort: []model.SortKey{{Field: "price"}}}
+
+	// Walk the listing through encoded cursors, as clients do
+	var walked []model.Product
+	var cursor *model.ProductCursor
+	for range 3 {
+		page, err := store.GetProductsAfter(context.Background(), filter, cursor)
+		if err != nil {
+			t.Fatalf("GetProductsAfter: %v", err)
+		}
+		walked = append(walked, page...)
+		if len(page) < filter.Limit {
+			break
+		}
+		next := model.NewProductCursor(page[len(page)-1], filter.Sort)
+		if cursor, err = model.DecodeProductCursor(next.Encode()); err != nil {
+			t.Fatalf("DecodeProductCursor: %v", err)
+		}
+	}
+	equalNames(t, walked, "Mouse", "Keyboard", "Monitor", "Laptop")
+}
+
+func testEachProduct(t *testing.T, store repository.ProductStore) {
+	seedProducts(t, store)
+	filter := model.ProductFilter{Page: 2, Limit: 1, Sort: []model.SortKey{{Field: "price", Desc: true}}}
+	var got []model.Product
+	err := store.EachProduct(context.Background(), filter, func(product model.Product) error {
+		got = append(got, product)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachProduct: %v", err)
+	}
+	equalNames(t, got, "Laptop", "Monitor", "Keyboard", "Mouse")
+
+	category := 2
+	got = nil
+	err = store.EachProduct(context.Background(), model.ProductFilter{CategoryID: &category}, func(product model.Product) error {
+		got = append(got, product)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachProduct of a category: %v", err)
+	}
+	equalNames(t, got, "Mouse", "Keyboard")
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.EachProduct(context.Background(), listFilter(), func(product model.Product) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("EachProduct stopped by fn: got %v after %d calls, want the error of fn after 1", err, calls)
+	}
+}
+
+func testAdjustStock(t *testing.T, store repository.ProductStore) {
+	products := seedProducts(t, store)
+	levels, err := store.AdjustStock(context.Background(), []model.StockAdjustment{
+		{ProductID: products[0].ID, Delta: -2, Reason: model.ReasonSale},
+		{ProductID: products[1].ID, Delta: 10, Reason: model.ReasonRestock},
+		{ProductID: products[0].ID, Delta: -3, Reason: model.ReasonSale},
+	}, "tester")
+	if err != nil {
+		t.Fatalf("AdjustStock: %v", err)
+	}
+	want := []model.StockLevel{
+		{ProductID: products[0].ID, Stock: 3},
+		{ProductID: products[1].ID, Stock: 10},
+		{ProductID: products[0].ID, Stock: 0},
+	}
+	if len(levels) != len(want) {
+		t.Fatalf("AdjustStock levels = %+v, want %+v", levels, want)
+	}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Fatalf("AdjustStock levels = %+v, want %+v", levels, want)
+		}
+	}
+
+	got, err := store.GetProductByID(context.Background(), products[0].ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if got.Stock != 0 || got.Available != 0 {
+		t.Errorf("stock after AdjustStock = %d (%d available), want 0", got.Stock, got.Available)
+	}
+}
+
+func testAdjustStockAllOrNothing(t *testing.T, store repository.ProductStore) {
+	products := seedProducts(t, store)
+	_, err := store.AdjustStock(context.Background(), []model.StockAdjustment{
+		{ProductID: products[0].ID, Delta: -1, Reason: model.ReasonSale},
+		{ProductID: products[1].ID, Delta: -1, Reason: model.ReasonSale},
+		{ProductID: 42, Delta: 1, Reason: model.ReasonRestock},
+	}, "tester")
+
+	var stockErr *repository.StockError
+	if !errors.As(err, &stockErr) || !errors.Is(err, model.ErrConflict) {
+		t.Fatalf("AdjustStock: got %v, want a *StockError", err)
+	}
+	if len(stockErr.Lines) != 2 {
+		t.Fatalf("rejected lines = %+v, want lines 2 and 3", stockErr.Lines)
+	}
+	insufficient, missing := stockErr.Lines[0], stockErr.Lines[1]
+	if insufficient.Line != 2 || insufficient.Error != "insufficient stock" || insufficient.Available == nil || *insufficient.Available != 0 {
+		t.Errorf("line 2 error = %+v, want insufficient stock with 0 available", insufficient)
+	}
+	if missing.Line != 3 || missing.Error != "product not found" || missing.Available != nil {
+		t.Errorf("line 3 error = %+v, want product not found", missing)
+	}
+
+	got, err := store.GetProductByID(context.Background(), products[0].ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if got.Stock != products[0].Stock {
+		t.Errorf("stock after a rejected batch = %d, want %d", got.Stock, products[0].Stock)
+	}
+	movements, err := store.GetStockMovements(context.Background(), products[0].ID, model.StockMovementFilter{Page: 1, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetStockMovements: %v", err)
+	}
+	if len(movements) != 1 {
+		t.Errorf("a rejected batch left %d movements, want only the opening stock", len(movements))
+	}
+}
+
+func testStockMovements(t *testing.T, store repository.ProductStore) {
+	product := seedProducts(t, store)[0]
+	if _, err := store.AdjustStock(context.Background(), []model.StockAdjustment{
+		{ProductID: product.ID, Delta: -1, Reason: model.ReasonSale},
+	}, "cashier"); err != nil {
+		t.Fatalf("AdjustStock: %v", err)
+	}
+	product.Version++ // bumped by the adjustment
+	product.Stock = 10
+	if err := store.UpdateProduct(context.Background(), &product, "manager"); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+
+	movements, err := store.GetStockMovements(context.Background(), product.ID, model.StockMovementFilter{Page: 1, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetStockMovements: %v", err)
+	}
+	want := []model.StockMovement{
+		{ProductID: product.ID, Delta: 6, Quantity: 10, Reason: model.ReasonAdjustment, Actor: "manager"},
+		{ProductID: product.ID, Delta: -1, Quantity: 4, Reason: model.ReasonSale, Actor: "cashier"},
+		{ProductID: product.ID, Delta: 5, Quantity: 5, Reason: model.ReasonRestock, Actor: "tester"},
+	}
+	if len(movements) != len(want) {
+		t.Fatalf("got %d movements, want %d", len(movements), len(want))
+	}
+	for i, movement := range movements {
+		if movement.CreatedAt.IsZero() {
+			t.Errorf("movement %d has no timestamp", i)
+		}
+		movement.ID, movement.CreatedAt = 0, want[i].CreatedAt
+		if movement != want[i] {
+			t.Errorf("movement %d = %+v, want %+v", i, movement, want[i])
+		}
+	}
+
+	page, err := store.GetStockMovements(context.Background(), product.ID, model.StockMovementFilter{Page: 2, Limit: 2})
+	if err != nil {
+		t.Fatalf("GetStockMovements page 2: %v", err)
+	}
+	if len(page) != 1 || page[0].Reason != model.ReasonRestock {
+		t.Errorf("page 2 = %+v, want the opening stock", page)
+	}
+
+	future := movements[0].CreatedAt.Add(time.Hour)
+	later, err := store.GetStockMovements(context.Background(), product.ID, model.StockMovementFilter{From: &future, Page: 1, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetStockMovements from the future: %v", err)
+	}
+	if len(later) != 0 {
+		t.Errorf("movements from the future = %+v, want none", later)
+	}
+}
+
+func testInventoryStats(t *testing.T, store repository.ProductStore) {
+	stats, err := store.GetInventoryStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetInventoryStats of an empty store: %v", err)
+	}
+	if stats != (model.InventoryStats{}) {
+		t.Errorf("GetInventoryStats of an empty store = %+v, want zeros", stats)
+	}
+
+	seedProducts(t, store)
+	stats, err = store.GetInventoryStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetInventoryStats: %v", err)
+	}
+	want := model.InventoryStats{Products: 4, OutOfStock: 1, Value: 999.5*5 + 80*12 + 300*3}
+	if stats != want {
+		t.Errorf("GetInventoryStats = %+v, want %+v", stats, want)
+	}
+}
+
+func testRegisterAndGet(t *testing.T, store repository.UserStore) {
+	admin := model.User{Username: "alice", Password: "hash-a", Role: "viewer"}
+	viewer := model.User{Username: "bob", Password: "hash-b", Role: "viewer"}
+	for _, user := range []*model.User{&admin, &viewer} {
+		if err := store.RegisterUser(user); err != nil {
+			t.Fatalf("RegisterUser(%q): %v", user.Username, err)
+		}
+	}
+	if admin.ID == 0 || viewer.ID == 0 || admin.ID == viewer.ID {
+		t.Fatalf("RegisterUser gave the IDs %d and %d", admin.ID, viewer.ID)
+	}
+
+	byName, err := store.GetUserByUsername("bob")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	if *byName != viewer {
+		t.Errorf("GetUserByUsername = %+v, want %+v", *byName, viewer)
+	}
+	byID, err := store.GetUserByID(admin.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if *byID != admin {
+		t.Errorf("GetUserByID = %+v, want %+v", *byID, admin)
+	}
+}
+
+// Admins are only ever created on purpose, even the first user of an empty
+// store is registered with the role asked for
+func testRegisterKeepsRole(t *testing.T, store repository.UserStore) {
+	first := model.User{Username: "alice", Password: "hash", Role: "viewer"}
+	second := model.User{Username: "bob", Password: "hash", Role: "admin"}
+	for _, user := range []*model.User{&first, &second} {
+		if err := store.RegisterUser(user); err != nil {
+			t.Fatalf("RegisterUser(%q): %v", user.Username, err)
+		}
+		stored, err := store.GetUserByID(user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if user.Role != stored.Role {
+			t.Errorf("%s was registered as %q but stored as %q", user.Username, user.Role, stored.Role)
+		}
+	}
+	if first.Role != "viewer" || second.Role != "admin" {
+		t.Errorf("roles = %q and %q, want viewer and admin", first.Role, second.Role)
+	}
+}
+
+func testDuplicateUsername(t *testing.T, store repository.UserStore) {
+	if err := store.RegisterUser(&model.User{Username: "alice", Password: "hash", Role: "viewer"}); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	duplicate := model.User{Username: "alice", Password: "other", Role: "viewer"}
+	err := store.RegisterUser(&duplicate)
+	if !errors.Is(err, repository.ErrUsernameTaken) || !errors.Is(err, model.ErrConflict) {
+		t.Errorf("RegisterUser of a taken username: got %v, want ErrUsernameTaken", err)
+	}
+
+	user, err := store.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	if user.Password != "hash" {
+		t.Errorf("a rejected registration replaced the password hash")
+	}
+}
+
+func testUserNotFound(t *testing.T, store repository.UserStore) {
+	checks := map[string]error{}
+	_, checks["GetUserByUsername"] = store.GetUserByUsername("nobody")
+	_, checks["GetUserByID"] = store.GetUserByID(42)
+	checks["UpdatePassword"] = store.UpdatePassword(42, "hash")
+	checks["UpdateRole"] = store.UpdateRole(42, "manager")
+	for method, err := range checks {
+		if !errors.Is(err, repository.ErrUserNotFound) || !errors.Is(err, model.ErrNotFound) {
+			t.Errorf("%s of a missing user: got %v, want ErrUserNotFound", method, err)
+		}
+	}
+}
+
+func testUpdatePassword(t *testing.T, store repository.UserStore) {
+	user := model.User{Username: "alice", Password: "old", Role: "viewer"}
+	if err := store.RegisterUser(&user); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	if err := store.UpdatePassword(user.ID, "new"); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	got, err := store.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.Password != "new" {
+		t.Errorf("password hash = %q, want %q", got.Password, "new")
+	}
+}
+
+func testUpdateRole(t *testing.T, store repository.UserStore) {
+	user := model.User{Username: "alice", Password: "hash", Role: "viewer"}
+	if err := store.RegisterUser(&user); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	if err := store.UpdateRole(user.ID, "manager"); err != nil {
+		t.Fatalf("UpdateRole: %v", err)
+	}
+	got, err := store.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	if got.Role != "manager" {
+		t.Errorf("role = %q, want manager", got.Role)
+	}
+}
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"database/sql"
+	"ecommerce-inventory/model"
+	"time"
+)
+
+var (
+	ErrRefreshTokenInvalid = model.NewError(model.ErrUnauthorized, "invalid or expired refresh token")
+	ErrRefreshTokenReused  = model.NewError(model.ErrUnauthorized, "refresh token was already used or revoked, its session is now revoked")
+)
+
+type TokenRepository struct {
+	db *sql.DB
+}
+
+func NewTokenRepository(db *sql.DB) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+// Store a new refresh token
+func (repo *TokenRepository) CreateRefreshToken(token *model.RefreshToken) error {
+	result, err := repo.db.Exec(`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`, token.UserID, token.TokenHash, token.FamilyID, token.ExpiresAt.UTC(), token.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	token.ID = int(id)
+	return nil
+}
+
+// Replace the refresh token with the given hash by next, which joins its
+// family. Presenting a token that was already rotated or revoked revokes
+// the whole family, since either the client or an attacker holds a stolen copy.
+func (repo *TokenRepository) RotateRefreshToken(tokenHash string, next *model.RefreshToken) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := next.CreatedAt.UTC()
+	var current model.RefreshToken
+	err = tx.QueryRow(`SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = ?`, tokenHash).
+		Scan(&current.ID, &current.UserID, &current.FamilyID, &current.ExpiresAt, &current.RevokedAt)
+	if err == sql.ErrNoRows {
+		return ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.RevokedAt != nil {
+		if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`,
+			now, current.FamilyID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+	if !current.ExpiresAt.After(now) {
+		return ErrRefreshTokenInvalid
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE id = ?`, now, current.ID); err != nil {
+		return err
+	}
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	result, err := tx.Exec(`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`, next.UserID, next.TokenHash, next.FamilyID, next.ExpiresAt.UTC(), now)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	next.ID = int(id)
+	return nil
+}
+
+// Revoke the refresh token with the given hash along with its whole family
+func (repo *TokenRepository) RevokeRefreshToken(tokenHash string, now time.Time) error {
+	_, err := repo.db.Exec(`UPDATE refresh_tokens SET revoked_at = ?
+		WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = ?) AND revoked_at IS NULL`,
+		now.UTC(), tokenHash)
+	return err
+}
+
+// Revoke an access token until it expires
+func (repo *TokenRepository) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := repo.db.Exec(`INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`, jti, expiresAt.UTC())
+	return err
+}
+
+// Revoke every refresh token of a user and every access token issued to
+// them up to now
+func (repo *TokenRepository) RevokeUserSessions(userID int, now time.Time) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET sessions_revoked_at = ? WHERE id = ?`, now.UTC().Truncate(time.Second), userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`,
+		now.UTC(), userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Check whether an access token was revoked, either on its own or along with
+// every session of its subject. Token issue times only have second precision,
+// so a token issued in the second its sessions were revoked counts as revoked.
+func (repo *TokenRepository) IsAccessTokenRevoked(jti, subject string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := repo.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		OR EXISTS (SELECT 1 FROM users WHERE username = ? AND sessions_revoked_at >= ?)`,
+		jti, subject, issuedAt.UTC().Truncate(time.Second)).Scan(&revoked)
+	return revoked, err
+}
+
+// Delete refresh tokens and access token revocations that have expired
+func (repo *TokenRepository) DeleteExpiredTokens(now time.Time) (int64, error) {
+	result, err := repo.db.Exec(`DELETE FROM refresh_tokens WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	refreshTokens, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err = repo.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	revocations, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return refreshTokens + revocations, nil
+}
@@ -0,0 +1,46 @@
+package repository_test
+
+import (
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRevokeUserSessions(t *testing.T) {
+	db := openDatabase(t)
+	user := &model.User{Username: "alice", Password: "hash"}
+	if err := repository.NewUserRepository(db).RegisterUser(user); err != nil {
+		t.Fatal(err)
+	}
+	tokens := repository.NewTokenRepository(db)
+
+	revokedAt := time.Date(2024, 5, 1, 12, 0, 0, 500_000_000, time.UTC)
+	if err := tokens.RevokeUserSessions(user.ID, revokedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"earlier second", revokedAt.Add(-time.Second).Truncate(time.Second), true},
+		{"same second", revokedAt.Truncate(time.Second), true},
+		{"next second", revokedAt.Add(time.Second).Truncate(time.Second), false},
+	}
+	for _, test := range tests {
+		revoked, err := tokens.IsAccessTokenRevoked("jti", "alice", test.issuedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != test.want {
+			t.Errorf("%s: revoked = %v, want %v", test.name, revoked, test.want)
+		}
+	}
+
+	if err := tokens.RevokeUserSessions(user.ID+1, revokedAt); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("revoking the sessions of a missing user returned %v, want ErrUserNotFound", err)
+	}
+}
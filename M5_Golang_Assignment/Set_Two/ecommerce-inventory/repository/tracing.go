@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("ecommerce-inventory/repository")
+
+// startQuery starts a client span around the database work of a repository
+// method, reading the rows included. operation is the main SQL statement run
+// and table the table it runs on.
+func startQuery(ctx context.Context, name, operation, table string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName(table),
+		))
+}
@@ -4,6 +4,13 @@ import (
 	"database/sql"
 	"ecommerce-inventory/model"
 	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrUserNotFound  = model.NewError(model.ErrNotFound, "user not found")
+	ErrUsernameTaken = model.NewError(model.ErrConflict, "username is already taken")
 )
 
 type UserRepository struct {
@@ -16,19 +23,77 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 
 // Get user by username
 func (repo *UserRepository) GetUserByUsername(username string) (*model.User, error) {
-	row := repo.db.QueryRow(`SELECT id, username, password FROM users WHERE username = ?`, username)
+	row := repo.db.QueryRow(`SELECT id, username, password, role FROM users WHERE username = ?`, username)
 	user := &model.User{}
-	if err := row.Scan(&user.ID, &user.Username, &user.Password); err != nil {
+	if err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
 	return user, nil
 }
 
-// Register a new user
+// Get user by ID
+func (repo *UserRepository) GetUserByID(id int) (*model.User, error) {
+	row := repo.db.QueryRow(`SELECT id, username, password, role FROM users WHERE id = ?`, id)
+	user := &model.User{}
+	if err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// Register a new user with the given role
 func (repo *UserRepository) RegisterUser(user *model.User) error {
-	_, err := repo.db.Exec(`INSERT INTO users (username, password) VALUES (?, ?)`, user.Username, user.Password)
-	return err
+	row := repo.db.QueryRow(`INSERT INTO users (username, password, role) VALUES (?, ?, ?) RETURNING id`,
+		user.Username, user.Password, user.Role)
+	if err := row.Scan(&user.ID); err != nil {
+		if isUniqueViolation(err) {
+			return ErrUsernameTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// Replace the password hash of a user
+func (repo *UserRepository) UpdatePassword(id int, password string) error {
+	result, err := repo.db.Exec(`UPDATE users SET password = ? WHERE id = ?`, password, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Change the role of a user
+func (repo *UserRepository) UpdateRole(id int, role string) error {
+	result, err := repo.db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err comes from a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
 }
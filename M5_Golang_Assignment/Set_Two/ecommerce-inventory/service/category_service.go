@@ -0,0 +1,62 @@
+package service
+
+import (
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"errors"
+)
+
+type CategoryService struct {
+	repo *repository.CategoryRepository
+}
+
+func NewCategoryService(repo *repository.CategoryRepository) *CategoryService {
+	return &CategoryService{repo: repo}
+}
+
+// Add a category
+func (service *CategoryService) AddCategory(category *model.Category) error {
+	if err := service.validateCategory(category); err != nil {
+		return err
+	}
+	return service.repo.AddCategory(category)
+}
+
+// Get a category by ID
+func (service *CategoryService) GetCategoryByID(id int) (*model.Category, error) {
+	return service.repo.GetCategoryByID(id)
+}
+
+// Get all categories
+func (service *CategoryService) GetAllCategories() ([]model.Category, error) {
+	return service.repo.GetAllCategories()
+}
+
+// Update a category
+func (service *CategoryService) UpdateCategory(category *model.Category) error {
+	if err := service.validateCategory(category); err != nil {
+		return err
+	}
+	return service.repo.UpdateCategory(category)
+}
+
+// Delete a category
+func (service *CategoryService) DeleteCategory(id int) error {
+	return service.repo.DeleteCategory(id)
+}
+
+// validateCategory checks the name and that the parent category exists
+func (service *CategoryService) validateCategory(category *model.Category) error {
+	if category.Name == "" {
+		return model.NewError(model.ErrValidation, "invalid category data")
+	}
+	if category.ParentID != nil {
+		if _, err := service.repo.GetCategoryByID(*category.ParentID); err != nil {
+			if errors.Is(err, repository.ErrCategoryNotFound) {
+				return model.NewError(model.ErrValidation, "parent category does not exist")
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"ecommerce-inventory/migrations"
+	"fmt"
+	"time"
+)
+
+// pingTimeout bounds how long a readiness check waits for the database
+const pingTimeout = 2 * time.Second
+
+type HealthService struct {
+	db *sql.DB
+}
+
+func NewHealthService(db *sql.DB) *HealthService {
+	return &HealthService{db: db}
+}
+
+// CheckDatabase reports whether the database answers a ping
+func (service *HealthService) CheckDatabase(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return service.db.PingContext(ctx)
+}
+
+// CheckMigrations reports whether the schema matches this binary, with no
+// pending migrations and none it does not know
+func (service *HealthService) CheckMigrations() error {
+	pending, err := migrations.Pending(service.db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations", len(pending))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsTimeout bounds how long a scrape waits for the inventory stats
+const metricsTimeout = 5 * time.Second
+
+var (
+	inventoryProductsDesc = prometheus.NewDesc("ecommerce_inventory_products",
+		"Number of products (SKUs) in the inventory.", nil, nil)
+	inventoryOutOfStockDesc = prometheus.NewDesc("ecommerce_inventory_out_of_stock_products",
+		"Number of products with no available stock.", nil, nil)
+	inventoryValueDesc = prometheus.NewDesc("ecommerce_inventory_value",
+		"Price of all the stock on hand.", nil, nil)
+)
+
+// RegisterMetrics registers gauges summing up the inventory. They are read
+// from the store on every scrape rather than kept up to date.
+func (service *ProductService) RegisterMetrics(registerer prometheus.Registerer) error {
+	return registerer.Register(inventoryCollector{service: service})
+}
+
+type inventoryCollector struct {
+	service *ProductService
+}
+
+func (collector inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- inventoryProductsDesc
+	ch <- inventoryOutOfStockDesc
+	ch <- inventoryValueDesc
+}
+
+func (collector inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+	defer cancel()
+
+	stats, err := collector.service.repo.GetInventoryStats(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(inventoryProductsDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(inventoryProductsDesc, prometheus.GaugeValue, float64(stats.Products))
+	ch <- prometheus.MustNewConstMetric(inventoryOutOfStockDesc, prometheus.GaugeValue, float64(stats.OutOfStock))
+	ch <- prometheus.MustNewConstMetric(inventoryValueDesc, prometheus.GaugeValue, stats.Value)
+}
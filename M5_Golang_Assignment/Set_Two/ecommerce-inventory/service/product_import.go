@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"ecommerce-inventory/catalog"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/tracing"
+	"ecommerce-inventory/validation"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MaxImportRows is the largest number of rows a single import may have
+const MaxImportRows = 10000
+
+// Statuses of an imported row
+const (
+	ImportRowCreated = "created"
+	ImportRowValid   = "valid"
+	ImportRowInvalid = "invalid"
+)
+
+// ImportOptions control how an import treats invalid rows
+type ImportOptions struct {
+	// Atomic imports nothing unless every row is valid, otherwise the valid
+	// rows are imported and the invalid ones skipped
+	Atomic bool
+	// DryRun validates the rows without importing any of them
+	DryRun bool
+}
+
+// ImportRow is the outcome of one row of an import
+type ImportRow struct {
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+	// ID is the ID of the created product
+	ID int `json:"id,omitempty"`
+	// Error tells why a row could not be read at all
+	Error  string            `json:"error,omitempty"`
+	Fields validation.Errors `json:"fields,omitempty"`
+}
+
+// ImportReport sums up an import row by row
+type ImportReport struct {
+	Mode    string      `json:"mode"`
+	DryRun  bool        `json:"dry_run"`
+	Total   int         `json:"total"`
+	Created int         `json:"created"`
+	Valid   int         `json:"valid"`
+	Invalid int         `json:"invalid"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// ImportError is returned when an atomic import has invalid rows, so that
+// nothing was imported. Its report tells which rows are at fault.
+type ImportError struct {
+	Report *ImportReport
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("%d of %d rows are invalid, nothing was imported", e.Report.Invalid, e.Report.Total)
+}
+
+func (e *ImportError) Unwrap() error {
+	return model.ErrValidation
+}
+
+// Import the products of a catalog, validating every row like AddProduct.
+// Depending on the options, invalid rows fail the whole import with an
+// *ImportError or are skipped, and a dry run only reports what would happen.
+// The valid rows are added in a single transaction.
+func (service *ProductService) ImportProducts(ctx context.Context, records []catalog.Record, options ImportOptions, actor string) (report *ImportReport, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.ImportProducts", trace.WithAttributes(attribute.Int("rows", len(records)),
+		attribute.Bool("atomic", options.Atomic), attribute.Bool("dry_run", options.DryRun), attribute.String("actor", actor)))
+	defer func() { tracing.End(span, err) }()
+
+	if len(records) == 0 {
+		return nil, model.NewError(model.ErrValidation, "the import has no rows")
+	}
+	if len(records) > MaxImportRows {
+		return nil, model.NewError(model.ErrValidation, fmt.Sprintf("the import has more than %d rows", MaxImportRows))
+	}
+
+	report = &ImportReport{Mode: "best_effort", DryRun: options.DryRun, Total: len(records), Rows: make([]ImportRow, len(records))}
+	if options.Atomic {
+		report.Mode = "atomic"
+	}
+
+	// Rows tend to share a handful of categories, so each is looked up once
+	categories := &categoryCache{CategoryFinder: service.categories, found: map[int]categoryLookup{}}
+	var products []model.Product
+	var rows []int
+	for i, record := range records {
+		row := &report.Rows[i]
+		row.Line = record.Line
+		if record.Err != nil {
+			row.Status = ImportRowInvalid
+			row.Error = record.Err.Error()
+			report.Invalid++
+			continue
+		}
+
+		err := checkProduct(&record.Product, categories)
+		var fields validation.Errors
+		if errors.As(err, &fields) {
+			row.Status = ImportRowInvalid
+			row.Fields = fields
+			report.Invalid++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		row.Status = ImportRowValid
+		report.Valid++
+		products = append(products, record.Product)
+		rows = append(rows, i)
+	}
+	span.SetAttributes(attribute.Int("rows.invalid", report.Invalid))
+
+	if options.Atomic && report.Invalid > 0 {
+		return nil, &ImportError{Report: report}
+	}
+	if options.DryRun || len(products) == 0 {
+		return report, nil
+	}
+
+	if err := service.repo.AddProducts(ctx, products, actor); err != nil {
+		return nil, err
+	}
+	for i, product := range products {
+		row := &report.Rows[rows[i]]
+		row.Status = ImportRowCreated
+		row.ID = product.ID
+	}
+	report.Created, report.Valid = len(products), 0
+	return report, nil
+}
+
+// categoryCache remembers the outcome of looking up each category
+type categoryCache struct {
+	CategoryFinder
+	found map[int]categoryLookup
+}
+
+type categoryLookup struct {
+	category *model.Category
+	err      error
+}
+
+func (cache *categoryCache) GetCategoryByID(id int) (*model.Category, error) {
+	lookup, ok := cache.found[id]
+	if !ok {
+		lookup.category, lookup.err = cache.CategoryFinder.GetCategoryByID(id)
+		cache.found[id] = lookup
+	}
+	return lookup.category, lookup.err
+}
+
+// Export every product matching a filter, ignoring its page and limit, by
+// calling fn with each in turn. Products are read as fn consumes them rather
+// than all at once.
+func (service *ProductService) ExportProducts(ctx context.Context, filter model.ProductFilter, fn func(model.Product) error) (err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.ExportProducts")
+	defer func() { tracing.End(span, err) }()
+
+	if err := service.validateFilter(ctx, filter); err != nil {
+		return err
+	}
+	var exported int
+	defer func() { span.SetAttributes(attribute.Int("products", exported)) }()
+	return service.repo.EachProduct(ctx, filter, func(product model.Product) error {
+		exported++
+		return fn(product)
+	})
+}
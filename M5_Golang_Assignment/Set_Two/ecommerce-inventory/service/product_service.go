@@ -1,50 +1,402 @@
 package service
 
 import (
+	"context"
 	"ecommerce-inventory/model"
 	"ecommerce-inventory/repository"
+	"ecommerce-inventory/tracing"
+	"ecommerce-inventory/validation"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrInvalidFilter is wrapped by errors about malformed product listing filters
+var ErrInvalidFilter = model.NewError(model.ErrValidation, "invalid product filter")
+
+var tracer = otel.Tracer("ecommerce-inventory/service")
+
+// CategoryFinder looks up the categories products point at
+type CategoryFinder interface {
+	GetCategoryByID(id int) (*model.Category, error)
+}
+
 type ProductService struct {
-	repo *repository.ProductRepository
+	repo       repository.ProductStore
+	categories CategoryFinder
+	// maxLimit is the largest page size of a listing
+	maxLimit int
 }
 
-func NewProductService(repo *repository.ProductRepository) *ProductService {
-	return &ProductService{repo: repo}
+func NewProductService(repo repository.ProductStore, categories CategoryFinder, maxLimit int) *ProductService {
+	return &ProductService{repo: repo, categories: categories, maxLimit: maxLimit}
 }
 
 // Add a product
-func (service *ProductService) AddProduct(product *model.Product) error {
-	if product.Name == "" || product.Price <= 0 || product.Stock < 0 {
-		return errors.New("invalid product data")
+func (service *ProductService) AddProduct(ctx context.Context, product *model.Product, actor string) (err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.AddProduct", trace.WithAttributes(attribute.String("actor", actor)))
+	defer func() { tracing.End(span, err) }()
+
+	if err := service.validateProduct(ctx, product); err != nil {
+		return err
 	}
-	return service.repo.AddProduct(product)
+	return service.repo.AddProduct(ctx, product, actor)
 }
 
 // Get a product by ID
-func (service *ProductService) GetProductByID(id int) (*model.Product, error) {
-	product, err := service.repo.GetProductByID(id)
+func (service *ProductService) GetProductByID(ctx context.Context, id int) (product *model.Product, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.GetProductByID", trace.WithAttributes(attribute.Int("product.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	return service.repo.GetProductByID(ctx, id)
+}
+
+// Update a product
+func (service *ProductService) UpdateProduct(ctx context.Context, product *model.Product, actor string) (err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.UpdateProduct", trace.WithAttributes(attribute.Int("product.id", product.ID), attribute.String("actor", actor)))
+	defer func() { tracing.End(span, err) }()
+
+	if err := service.validateProduct(ctx, product); err != nil {
+		return err
+	}
+	return service.repo.UpdateProduct(ctx, product, actor)
+}
+
+// ProductPatch applies a patch to the JSON document of a product, such as a
+// JSON Merge Patch or a JSON Patch
+type ProductPatch func(document []byte) ([]byte, error)
+
+// patchableProduct is the document a ProductPatch applies to, holding the
+// fields a client may change
+type patchableProduct struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	Stock       int     `json:"stock"`
+	CategoryID  int     `json:"category_id"`
+}
+
+// patchAttempts bounds how often a patch based on repository.AnyVersion is
+// applied when the product keeps changing underneath it
+const patchAttempts = 3
+
+// Apply a patch to a product based on the given version, or on the current
+// one with repository.AnyVersion, validate the result and store the fields it
+// changed. The product is returned as stored.
+func (service *ProductService) PatchProduct(ctx context.Context, id, version int, patch ProductPatch, actor string) (product *model.Product, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.PatchProduct", trace.WithAttributes(attribute.Int("product.id", id), attribute.String("actor", actor)))
+	defer func() { tracing.End(span, err) }()
+
+	// The write is always conditional on the version the patch was applied
+	// to. Without a version from the client, a product that changed in the
+	// meantime is read again and the patch applied to it anew.
+	for attempt := 1; ; attempt++ {
+		product, err = service.patchProduct(ctx, id, version, patch, actor)
+		if version != repository.AnyVersion || attempt == patchAttempts || !errors.Is(err, repository.ErrProductVersionMismatch) {
+			return product, err
+		}
+	}
+}
+
+// patchProduct applies a patch once to the product as currently stored
+func (service *ProductService) patchProduct(ctx context.Context, id, version int, patch ProductPatch, actor string) (*model.Product, error) {
+	current, err := service.repo.GetProductByID(ctx, id)
 	if err != nil {
-		return nil, errors.New("product not found")
+		return nil, err
 	}
-	return product, nil
+	if current.Version != version && version != repository.AnyVersion {
+		return nil, repository.ErrProductVersionMismatch
+	}
+
+	document, err := json.Marshal(patchableProduct{
+		Name:        current.Name,
+		Description: current.Description,
+		Price:       current.Price,
+		Stock:       current.Stock,
+		CategoryID:  current.CategoryID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	patched, err := patch(document)
+	if err != nil {
+		return nil, model.NewError(model.ErrConflict, "the patch cannot be applied: %v", err)
+	}
+	fields, err := decodePatchedProduct(patched)
+	if err != nil {
+		return nil, err
+	}
+
+	// updated keeps the version of current, so the write fails if the product
+	// changed since it was read
+	updated := *current
+	updated.Name = fields.Name
+	updated.Description = fields.Description
+	updated.Price = fields.Price
+	updated.Stock = fields.Stock
+	updated.CategoryID = fields.CategoryID
+	if err := service.validateProduct(ctx, &updated); err != nil {
+		return nil, err
+	}
+
+	// Only the changed columns are written, a patch changing nothing writes nothing
+	var changed []string
+	for _, field := range []struct {
+		name    string
+		differs bool
+	}{
+		{"name", updated.Name != current.Name},
+		{"description", updated.Description != current.Description},
+		{"price", updated.Price != current.Price},
+		{"stock", updated.Stock != current.Stock},
+		{"category_id", updated.CategoryID != current.CategoryID},
+	} {
+		if field.differs {
+			changed = append(changed, field.name)
+		}
+	}
+	if len(changed) == 0 {
+		return current, nil
+	}
+	if err := service.repo.UpdateProductFields(ctx, &updated, changed, actor); err != nil {
+		return nil, err
+	}
+	return service.repo.GetProductByID(ctx, id)
 }
 
-// Update a product
-func (service *ProductService) UpdateProduct(product *model.Product) error {
-	if product.Name == "" || product.Price <= 0 || product.Stock < 0 {
-		return errors.New("invalid product data")
+// patchableFields are the members of a patchableProduct
+var patchableFields = []string{"name", "description", "price", "stock", "category_id"}
+
+// decodePatchedProduct reads the document a patch produced. Fields that are
+// read-only or unknown are reported as field errors, and so are fields the
+// patch removed or set to null, which would otherwise read as zero.
+func decodePatchedProduct(document []byte) (patchableProduct, error) {
+	var fields patchableProduct
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(document, &members); err != nil {
+		return fields, model.NewError(model.ErrValidation, "the patched product is not a JSON object")
+	}
+	var invalid validation.Errors
+	for name := range members {
+		switch name {
+		case "name", "description", "price", "stock", "category_id":
+		case "id", "available", "version":
+			invalid = append(invalid, validation.FieldError{Field: name, Code: "read_only", Message: name + " cannot be changed"})
+		default:
+			invalid = append(invalid, validation.FieldError{Field: name, Code: "unknown", Message: name + " is not a product field"})
+		}
 	}
-	return service.repo.UpdateProduct(product)
+	for _, name := range patchableFields {
+		if value, ok := members[name]; !ok || string(value) == "null" {
+			invalid = append(invalid, validation.FieldError{Field: name, Code: "required", Message: "is required"})
+		}
+	}
+	if len(invalid) > 0 {
+		slices.SortFunc(invalid, func(a, b validation.FieldError) int { return strings.Compare(a.Field, b.Field) })
+		return fields, invalid
+	}
+	if err := json.Unmarshal(document, &fields); err != nil {
+		if invalid := validation.TypeError(err); invalid != nil {
+			return fields, invalid
+		}
+		return fields, model.NewError(model.ErrValidation, "the patched product is invalid: %v", err)
+	}
+	return fields, nil
 }
 
-// Delete a product
-func (service *ProductService) DeleteProduct(id int) error {
-	return service.repo.DeleteProduct(id)
+// Delete a product. It can be restored until it is purged.
+func (service *ProductService) DeleteProduct(ctx context.Context, id int) (err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.DeleteProduct", trace.WithAttributes(attribute.Int("product.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	return service.repo.DeleteProduct(ctx, id)
 }
 
-// Get all products with pagination
-func (service *ProductService) GetAllProducts(page, limit int) ([]model.Product, error) {
-	return service.repo.GetAllProducts(page, limit)
+// Restore a deleted product and return it
+func (service *ProductService) RestoreProduct(ctx context.Context, id int) (product *model.Product, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.RestoreProduct", trace.WithAttributes(attribute.Int("product.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	if err := service.repo.RestoreProduct(ctx, id); err != nil {
+		return nil, err
+	}
+	return service.repo.GetProductByID(ctx, id)
+}
+
+// StartPurgeWorker deletes for good the products deleted longer than
+// retention ago, every interval until ctx is cancelled.
+// The returned channel is closed once the worker has stopped.
+func (service *ProductService) StartPurgeWorker(ctx context.Context, interval, retention time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				purged, err := service.repo.PurgeDeletedProducts(ctx, now.Add(-retention))
+				if err != nil {
+					if ctx.Err() == nil {
+						slog.Error("Error purging deleted products", "error", err)
+					}
+					continue
+				}
+				if purged > 0 {
+					slog.Info("Purged deleted products", "count", purged)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// Get the products matching a filter with pagination
+func (service *ProductService) GetAllProducts(ctx context.Context, filter model.ProductFilter) (products []model.Product, total int, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.GetAllProducts", trace.WithAttributes(attribute.Int("page", filter.Page), attribute.Int("limit", filter.Limit)))
+	defer func() { tracing.End(span, err) }()
+
+	if filter.Page < 1 {
+		return nil, 0, fmt.Errorf("%w: page must be at least 1", ErrInvalidFilter)
+	}
+	if err := service.validateLimit(filter); err != nil {
+		return nil, 0, err
+	}
+	if err := service.validateFilter(ctx, filter); err != nil {
+		return nil, 0, err
+	}
+	return service.repo.GetAllProducts(ctx, filter)
+}
+
+// Get a keyset page of the products matching a filter that sort after the
+// cursor token, along with the cursor token of the next page. An empty token
+// starts from the first product, an empty next token means the listing is done.
+func (service *ProductService) GetProductsAfter(ctx context.Context, filter model.ProductFilter, token string) (products []model.Product, next string, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.GetProductsAfter", trace.WithAttributes(attribute.Int("limit", filter.Limit)))
+	defer func() { tracing.End(span, err) }()
+
+	if err := service.validateLimit(filter); err != nil {
+		return nil, "", err
+	}
+	if err := service.validateFilter(ctx, filter); err != nil {
+		return nil, "", err
+	}
+
+	var cursor *model.ProductCursor
+	if token != "" {
+		if cursor, err = model.DecodeProductCursor(token); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+		if err := cursor.Matches(filter.Sort); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+	}
+
+	// Fetching one extra product tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	products, err = service.repo.GetProductsAfter(ctx, filter, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(products) <= limit {
+		return products, "", nil
+	}
+	products = products[:limit]
+	return products, model.NewProductCursor(products[limit-1], filter.Sort).Encode(), nil
+}
+
+// validateLimit checks the page size of a listing
+func (service *ProductService) validateLimit(filter model.ProductFilter) error {
+	if filter.Limit < 1 || filter.Limit > service.maxLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, service.maxLimit)
+	}
+	return nil
+}
+
+// validateFilter checks the price range, sort keys and category of a filter
+func (service *ProductService) validateFilter(ctx context.Context, filter model.ProductFilter) error {
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return fmt.Errorf("%w: min_price must not be greater than max_price", ErrInvalidFilter)
+	}
+	for _, key := range filter.Sort {
+		if !slices.Contains(model.ProductSortFields, key.Field) {
+			return fmt.Errorf("%w: cannot sort by %q", ErrInvalidFilter, key.Field)
+		}
+	}
+	if filter.CategoryID != nil {
+		if _, err := service.categories.GetCategoryByID(*filter.CategoryID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateProduct checks a product against the rules declared on
+// model.Product and ensures it points at an existing category.
+// A category ID of 0 leaves the product uncategorized.
+func (service *ProductService) validateProduct(ctx context.Context, product *model.Product) error {
+	return checkProduct(product, service.categories)
+}
+
+// checkProduct validates a product like validateProduct, looking its
+// category up in categories
+func checkProduct(product *model.Product, categories CategoryFinder) error {
+	fields := validation.Struct(product)
+	if product.CategoryID > 0 {
+		_, err := categories.GetCategoryByID(product.CategoryID)
+		if errors.Is(err, repository.ErrCategoryNotFound) {
+			fields = append(fields, validation.FieldError{
+				Field:   "category_id",
+				Code:    "not_found",
+				Message: fmt.Sprintf("category %d does not exist", product.CategoryID),
+			})
+		} else if err != nil {
+			return err
+		}
+	}
+	return fields.Err()
+}
+
+// Adjust stock for a batch of products atomically
+func (service *ProductService) AdjustStock(ctx context.Context, adjustments []model.StockAdjustment, actor string) (levels []model.StockLevel, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.AdjustStock", trace.WithAttributes(attribute.Int("lines", len(adjustments)), attribute.String("actor", actor)))
+	defer func() { tracing.End(span, err) }()
+
+	if len(adjustments) == 0 {
+		return nil, model.NewError(model.ErrValidation, "no stock adjustments given")
+	}
+	for i, adjustment := range adjustments {
+		if adjustment.ProductID <= 0 || adjustment.Delta == 0 {
+			return nil, model.NewError(model.ErrValidation, "invalid stock adjustment on line %d", i+1)
+		}
+		if adjustment.Reason == "" {
+			adjustments[i].Reason = model.ReasonAdjustment
+		} else if !model.ValidMovementReason(adjustment.Reason) {
+			return nil, model.NewError(model.ErrValidation, "invalid stock movement reason %q on line %d", adjustment.Reason, i+1)
+		}
+	}
+	return service.repo.AdjustStock(ctx, adjustments, actor)
+}
+
+// Get the stock movements of a product
+func (service *ProductService) GetStockMovements(ctx context.Context, productID int, filter model.StockMovementFilter) (movements []model.StockMovement, err error) {
+	ctx, span := tracer.Start(ctx, "ProductService.GetStockMovements", trace.WithAttributes(attribute.Int("product.id", productID)))
+	defer func() { tracing.End(span, err) }()
+
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return nil, model.NewError(model.ErrValidation, "from must be before to")
+	}
+	return service.repo.GetStockMovements(ctx, productID, filter)
 }
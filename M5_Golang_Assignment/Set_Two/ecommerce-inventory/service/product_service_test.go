@@ -0,0 +1,157 @@
+package service_test
+
+import (
+	"context"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"ecommerce-inventory/repository/memory"
+	"ecommerce-inventory/service"
+	"ecommerce-inventory/validation"
+	"errors"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// categories finds every category
+type categories struct{}
+
+func (categories) GetCategoryByID(id int) (*model.Category, error) {
+	return &model.Category{ID: id, Name: "Category"}, nil
+}
+
+func mergePatch(patch string) service.ProductPatch {
+	return func(document []byte) ([]byte, error) {
+		return jsonpatch.MergePatch(document, []byte(patch))
+	}
+}
+
+func jsonPatch(t *testing.T, patch string) service.ProductPatch {
+	operations, err := jsonpatch.DecodePatch([]byte(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return operations.Apply
+}
+
+func TestPatchProduct(t *testing.T) {
+	store := memory.NewProductStore(nil)
+	products := service.NewProductService(store, categories{}, 100)
+	product := model.Product{Name: "Lamp", Description: "Desk lamp", Price: 20, Stock: 5, CategoryID: 3}
+	if err := store.AddProduct(context.Background(), &product, "tester"); err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := products.PatchProduct(context.Background(), product.ID, product.Version,
+		jsonPatch(t, `[{"op": "replace", "path": "/price", "value": 25}]`), "tester")
+	if err != nil {
+		t.Fatalf("PatchProduct: %v", err)
+	}
+	if patched.Price != 25 || patched.Stock != 5 || patched.Version != 2 {
+		t.Errorf("after patching the price got %+v", *patched)
+	}
+
+	// Removing a member or setting it to null must not zero it
+	for _, test := range []struct {
+		name  string
+		patch service.ProductPatch
+		field string
+	}{
+		{"null stock", mergePatch(`{"stock": null}`), "stock"},
+		{"null category", mergePatch(`{"category_id": null, "price": 30}`), "category_id"},
+		{"removed stock", jsonPatch(t, `[{"op": "remove", "path": "/stock"}]`), "stock"},
+		{"removed description", jsonPatch(t, `[{"op": "remove", "path": "/description"}]`), "description"},
+		{"replaced with null", jsonPatch(t, `[{"op": "replace", "path": "/name", "value": null}]`), "name"},
+	} {
+		_, err := products.PatchProduct(context.Background(), product.ID, repository.AnyVersion, test.patch, "tester")
+		var fields validation.Errors
+		if !errors.As(err, &fields) || len(fields) != 1 || fields[0].Field != test.field || fields[0].Code != "required" {
+			t.Errorf("%s: got %v, want a required error on %s", test.name, err, test.field)
+		}
+	}
+
+	got, err := store.GetProductByID(context.Background(), product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *patched {
+		t.Errorf("rejected patches changed the product to %+v, want %+v", *got, *patched)
+	}
+}
+
+// racingStore changes the price of a product right before the next writes
+// to it, like a concurrent client would
+type racingStore struct {
+	repository.ProductStore
+	races int
+}
+
+func (store *racingStore) UpdateProductFields(ctx context.Context, product *model.Product, fields []string, actor string) error {
+	if store.races > 0 {
+		store.races--
+		current, err := store.ProductStore.GetProductByID(ctx, product.ID)
+		if err != nil {
+			return err
+		}
+		current.Price++
+		if err := store.ProductStore.UpdateProductFields(ctx, current, []string{"price"}, "racer"); err != nil {
+			return err
+		}
+	}
+	return store.ProductStore.UpdateProductFields(ctx, product, fields, actor)
+}
+
+func TestPatchProductAnyVersionRace(t *testing.T) {
+	store := &racingStore{ProductStore: memory.NewProductStore(nil)}
+	products := service.NewProductService(store, categories{}, 100)
+	product := model.Product{Name: "Lamp", Description: "Desk lamp", Price: 20, Stock: 5, CategoryID: 3}
+	if err := store.AddProduct(context.Background(), &product, "tester"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The patch is applied again to the product the racer changed
+	store.races = 1
+	patched, err := products.PatchProduct(context.Background(), product.ID, repository.AnyVersion,
+		jsonPatch(t, `[{"op": "replace", "path": "/stock", "value": 7}]`), "tester")
+	if err != nil {
+		t.Fatalf("PatchProduct: %v", err)
+	}
+	if patched.Price != 21 || patched.Stock != 7 || patched.Version != 3 {
+		t.Errorf("after a racing price change got %+v", *patched)
+	}
+
+	// A test operation is checked against the product as written
+	store.races = 1
+	_, err = products.PatchProduct(context.Background(), product.ID, repository.AnyVersion,
+		jsonPatch(t, `[{"op": "test", "path": "/price", "value": 21}, {"op": "replace", "path": "/price", "value": 30}]`), "tester")
+	if !errors.Is(err, model.ErrConflict) {
+		t.Errorf("patch testing a price that raced: got %v, want ErrConflict", err)
+	}
+
+	// A product that keeps changing fails the patch eventually
+	store.races = 100
+	_, err = products.PatchProduct(context.Background(), product.ID, repository.AnyVersion,
+		jsonPatch(t, `[{"op": "replace", "path": "/stock", "value": 9}]`), "tester")
+	if !errors.Is(err, repository.ErrProductVersionMismatch) {
+		t.Errorf("patch of a product that keeps changing: got %v, want ErrProductVersionMismatch", err)
+	}
+
+	// A patch based on a given version is not applied again
+	store.races = 1
+	current, err := store.GetProductByID(context.Background(), product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = products.PatchProduct(context.Background(), product.ID, current.Version,
+		jsonPatch(t, `[{"op": "replace", "path": "/stock", "value": 9}]`), "tester")
+	if !errors.Is(err, repository.ErrProductVersionMismatch) || store.races != 0 {
+		t.Errorf("patch of a given version that raced: got %v, want ErrProductVersionMismatch", err)
+	}
+	got, err := store.GetProductByID(context.Background(), product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Stock != 7 {
+		t.Errorf("failed patches changed the stock to %d", got.Stock)
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"log/slog"
+	"time"
+)
+
+type ReservationService struct {
+	repo *repository.ReservationRepository
+	ttl  time.Duration
+}
+
+func NewReservationService(repo *repository.ReservationRepository, ttl time.Duration) *ReservationService {
+	return &ReservationService{repo: repo, ttl: ttl}
+}
+
+// Hold stock for the given items until the reservation TTL runs out
+func (service *ReservationService) CreateReservation(ctx context.Context, items []model.ReservationItem) (*model.Reservation, error) {
+	if len(items) == 0 {
+		return nil, model.NewError(model.ErrValidation, "no reservation items given")
+	}
+	seen := make(map[int]bool, len(items))
+	for i, item := range items {
+		if item.ProductID <= 0 || item.Quantity <= 0 {
+			return nil, model.NewError(model.ErrValidation, "invalid reservation item on line %d", i+1)
+		}
+		if seen[item.ProductID] {
+			return nil, model.NewError(model.ErrValidation, "duplicate product %d on line %d", item.ProductID, i+1)
+		}
+		seen[item.ProductID] = true
+	}
+
+	now := time.Now()
+	reservation := &model.Reservation{
+		Status:    model.ReservationActive,
+		Items:     items,
+		ExpiresAt: now.Add(service.ttl),
+		CreatedAt: now,
+	}
+	if err := service.repo.CreateReservation(ctx, reservation); err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// Get a reservation by ID
+func (service *ReservationService) GetReservationByID(ctx context.Context, id int) (*model.Reservation, error) {
+	return service.repo.GetReservationByID(ctx, id)
+}
+
+// Commit a reservation, turning its holds into stock deductions
+func (service *ReservationService) CommitReservation(ctx context.Context, id int, actor string) (*model.Reservation, error) {
+	return service.repo.CommitReservation(ctx, id, actor)
+}
+
+// Release a reservation without deducting stock
+func (service *ReservationService) ReleaseReservation(ctx context.Context, id int) error {
+	return service.repo.ReleaseReservation(ctx, id)
+}
+
+// StartExpiryWorker expires overdue reservations every interval until ctx is cancelled.
+// The returned channel is closed once the worker has stopped.
+func (service *ReservationService) StartExpiryWorker(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				expired, err := service.repo.ExpireReservations(ctx, now)
+				if err != nil {
+					slog.Error("Error expiring reservations", "error", err)
+					continue
+				}
+				if expired > 0 {
+					slog.Info("Expired reservations", "count", expired)
+				}
+			}
+		}
+	}()
+	return done
+}
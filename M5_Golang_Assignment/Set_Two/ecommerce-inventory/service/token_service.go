@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"ecommerce-inventory/auth"
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type TokenService struct {
+	repo       *repository.TokenRepository
+	users      repository.UserStore
+	keys       *auth.KeyManager
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokenService(repo *repository.TokenRepository, users repository.UserStore, keys *auth.KeyManager,
+	accessTTL, refreshTTL time.Duration) *TokenService {
+	return &TokenService{repo: repo, users: users, keys: keys, accessTTL: accessTTL, refreshTTL: refreshTTL}
+}
+
+// Issue an access token and a new refresh token family for a user who just logged in
+func (service *TokenService) IssueTokens(user *model.User) (*model.TokenPair, error) {
+	refreshToken, stored, err := service.newRefreshToken(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if stored.FamilyID, err = randomToken(16); err != nil {
+		return nil, err
+	}
+	if err := service.repo.CreateRefreshToken(stored); err != nil {
+		return nil, err
+	}
+	return service.tokenPair(user, refreshToken)
+}
+
+// Exchange a refresh token for a new access token and a new refresh token.
+// The presented refresh token cannot be used again.
+func (service *TokenService) Refresh(refreshToken string) (*model.TokenPair, error) {
+	nextToken, next, err := service.newRefreshToken(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.repo.RotateRefreshToken(hashToken(refreshToken), next); err != nil {
+		return nil, err
+	}
+
+	user, err := service.users.GetUserByID(next.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return service.tokenPair(user, nextToken)
+}
+
+// Log out by revoking the access token of the request and, when given, the
+// refresh token family of the session
+func (service *TokenService) Logout(claims *auth.Claims, refreshToken string) error {
+	if refreshToken != "" {
+		if err := service.repo.RevokeRefreshToken(hashToken(refreshToken), time.Now()); err != nil {
+			return err
+		}
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	return service.repo.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time)
+}
+
+// Revoke every session of a user, logging them out everywhere. Tokens issued
+// in the second of the revocation count as revoked, as issue times only have
+// second precision, so it returns once that second is over: tokens issued
+// after it returns stay valid.
+func (service *TokenService) RevokeUserSessions(userID int) error {
+	now := time.Now()
+	if err := service.repo.RevokeUserSessions(userID, now); err != nil {
+		return err
+	}
+	time.Sleep(time.Until(now.Truncate(time.Second).Add(time.Second)))
+	return nil
+}
+
+// IsRevoked reports whether an otherwise valid access token was revoked
+func (service *TokenService) IsRevoked(claims *auth.Claims) (bool, error) {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	return service.repo.IsAccessTokenRevoked(claims.ID, claims.Subject, issuedAt)
+}
+
+// StartCleanupWorker deletes expired tokens every interval until ctx is cancelled.
+// The returned channel is closed once the worker has stopped.
+func (service *TokenService) StartCleanupWorker(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _, err := service.repo.DeleteExpiredTokens(now); err != nil {
+					slog.Error("Error deleting expired tokens", "error", err)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// tokenPair signs an access token for user to go with a refresh token
+func (service *TokenService) tokenPair(user *model.User, refreshToken string) (*model.TokenPair, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	accessToken, err := service.keys.Sign(&auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(service.accessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "ecommerce-inventory",
+			Subject:   user.Username,
+		},
+		Role: user.Role,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(service.accessTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// newRefreshToken makes a random refresh token and the record storing its hash
+func (service *TokenService) newRefreshToken(userID int) (string, *model.RefreshToken, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	return token, &model.RefreshToken{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(service.refreshTTL),
+		CreatedAt: now,
+	}, nil
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken hashes a refresh token for storage. Refresh tokens are long and
+// random, so a fast unsalted hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,32 +1,103 @@
 package service
 
 import (
+	"crypto/subtle"
+	"ecommerce-inventory/auth"
 	"ecommerce-inventory/model"
 	"ecommerce-inventory/repository"
-	"errors"
+	"ecommerce-inventory/validation"
+	"log/slog"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned for an unknown username or a wrong password
+var ErrInvalidCredentials = model.NewError(model.ErrUnauthorized, "invalid credentials")
+
+// dummyHash is compared against when a username does not exist, so that
+// unknown and known usernames take as long to reject
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
 type UserService struct {
-	repo *repository.UserRepository
+	repo repository.UserStore
 }
 
-func NewUserService(repo *repository.UserRepository) *UserService {
+func NewUserService(repo repository.UserStore) *UserService {
 	return &UserService{repo: repo}
 }
 
-// Register a user
+// Register a user as a viewer, storing a bcrypt hash of the password
 func (service *UserService) RegisterUser(user *model.User) error {
-	if user.Username == "" || user.Password == "" {
-		return errors.New("invalid user data")
+	return service.register(user, auth.RoleViewer)
+}
+
+// Register a user as an admin. Nobody can become one through the API, so the
+// first admin of an install is created with this, from the command line.
+func (service *UserService) RegisterAdmin(user *model.User) error {
+	return service.register(user, auth.RoleAdmin)
+}
+
+func (service *UserService) register(user *model.User, role string) error {
+	user.Role = role
+	if err := validation.Struct(user).Err(); err != nil {
+		return err
+	}
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return err
 	}
+	user.Password = hash
 	return service.repo.RegisterUser(user)
 }
 
-// Authenticate user credentials
+// Authenticate user credentials. Passwords stored in plaintext by earlier
+// versions, or hashed with a lower cost, are rehashed on a successful login.
 func (service *UserService) AuthenticateUser(username, password string) (*model.User, error) {
 	user, err := service.repo.GetUserByUsername(username)
-	if err != nil || user.Password != password {
-		return nil, errors.New("invalid credentials")
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	if err != nil {
+		// Not a bcrypt hash, so a legacy plaintext password
+		if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+			return nil, ErrInvalidCredentials
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if cost < bcrypt.DefaultCost {
+		if hash, err := hashPassword(password); err != nil {
+			slog.Error("Error rehashing password", "user_id", user.ID, "error", err)
+		} else if err := service.repo.UpdatePassword(user.ID, hash); err != nil {
+			slog.Error("Error storing rehashed password", "user_id", user.ID, "error", err)
+		} else {
+			user.Password = hash
+		}
 	}
 	return user, nil
 }
+
+// Change the role of a user. Tokens already issued keep the old role until
+// they are refreshed.
+func (service *UserService) UpdateRole(id int, role string) error {
+	if !auth.ValidRole(role) {
+		return model.NewError(model.ErrValidation, "invalid role")
+	}
+	return service.repo.UpdateRole(id, role)
+}
+
+// hashPassword hashes a password with bcrypt, which only reads its first 72 bytes
+func hashPassword(password string) (string, error) {
+	if len(password) > 72 {
+		return "", model.NewError(model.ErrValidation, "password must be at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,116 @@
+package service_test
+
+import (
+	"ecommerce-inventory/model"
+	"ecommerce-inventory/repository/memory"
+	"ecommerce-inventory/service"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticateUser(t *testing.T) {
+	users := service.NewUserService(memory.NewUserStore())
+	if err := users.RegisterUser(&model.User{Username: "alice", Password: "password1"}); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+
+	user, err := users.AuthenticateUser("alice", "password1")
+	if err != nil {
+		t.Fatalf("AuthenticateUser: %v", err)
+	}
+	if user.Username != "alice" || user.Role != "viewer" {
+		t.Errorf("AuthenticateUser = %+v, want alice as a viewer", *user)
+	}
+
+	start := time.Now()
+	if _, err := users.AuthenticateUser("alice", "password2"); !errors.Is(err, service.ErrInvalidCredentials) {
+		t.Errorf("AuthenticateUser with a wrong password: got %v, want ErrInvalidCredentials", err)
+	}
+	wrongPassword := time.Since(start)
+
+	// An unknown username is checked against a dummy hash, so that it takes
+	// about as long to reject as a wrong password
+	start = time.Now()
+	if _, err := users.AuthenticateUser("mallory", "password1"); !errors.Is(err, service.ErrInvalidCredentials) {
+		t.Errorf("AuthenticateUser of an unknown user: got %v, want ErrInvalidCredentials", err)
+	}
+	if unknownUser := time.Since(start); unknownUser < wrongPassword/4 {
+		t.Errorf("rejecting an unknown user took %v, a wrong password %v", unknownUser, wrongPassword)
+	}
+}
+
+func TestAuthenticateUserRehashes(t *testing.T) {
+	store := memory.NewUserStore()
+	users := service.NewUserService(store)
+	weak, err := bcrypt.GenerateFromPassword([]byte("password2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stored as earlier versions did, in plaintext or with a lower cost
+	legacy := model.User{Username: "legacy", Password: "password1", Role: "viewer"}
+	cheap := model.User{Username: "cheap", Password: string(weak), Role: "viewer"}
+	for _, user := range []*model.User{&legacy, &cheap} {
+		if err := store.RegisterUser(user); err != nil {
+			t.Fatalf("RegisterUser: %v", err)
+		}
+	}
+
+	for _, test := range []struct {
+		user     model.User
+		password string
+	}{
+		{legacy, "password1"},
+		{cheap, "password2"},
+	} {
+		if _, err := users.AuthenticateUser(test.user.Username, "wrong password"); !errors.Is(err, service.ErrInvalidCredentials) {
+			t.Errorf("%s with a wrong password: got %v, want ErrInvalidCredentials", test.user.Username, err)
+		}
+		if stored, _ := store.GetUserByID(test.user.ID); stored.Password != test.user.Password {
+			t.Errorf("%s: a failed login changed the stored password", test.user.Username)
+		}
+
+		if _, err := users.AuthenticateUser(test.user.Username, test.password); err != nil {
+			t.Fatalf("%s: AuthenticateUser: %v", test.user.Username, err)
+		}
+		stored, err := store.GetUserByID(test.user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if cost, err := bcrypt.Cost([]byte(stored.Password)); err != nil || cost != bcrypt.DefaultCost {
+			t.Errorf("%s: the stored password has cost %d (%v) after logging in, want %d", test.user.Username, cost, err, bcrypt.DefaultCost)
+		}
+		if _, err := users.AuthenticateUser(test.user.Username, test.password); err != nil {
+			t.Errorf("%s: AuthenticateUser after the rehash: %v", test.user.Username, err)
+		}
+		if _, err := users.AuthenticateUser(test.user.Username, "wrong password"); !errors.Is(err, service.ErrInvalidCredentials) {
+			t.Errorf("%s with a wrong password after the rehash: got %v, want ErrInvalidCredentials", test.user.Username, err)
+		}
+	}
+}
+
+func TestRegisterUserPasswordLength(t *testing.T) {
+	users := service.NewUserService(memory.NewUserStore())
+	for i, test := range []struct {
+		password string
+		ok       bool
+	}{
+		{strings.Repeat("a", 72), true},
+		{strings.Repeat("é", 36), true},
+		// bcrypt ignores what comes after 72 bytes, however few characters that is
+		{strings.Repeat("a", 73), false},
+		{strings.Repeat("é", 40), false},
+	} {
+		err := users.RegisterUser(&model.User{Username: "user" + strconv.Itoa(i), Password: test.password})
+		if test.ok && err != nil {
+			t.Errorf("RegisterUser with a %d byte password: %v", len(test.password), err)
+		}
+		if !test.ok && !errors.Is(err, model.ErrValidation) {
+			t.Errorf("RegisterUser with a %d byte password: got %v, want a validation error", len(test.password), err)
+		}
+	}
+}
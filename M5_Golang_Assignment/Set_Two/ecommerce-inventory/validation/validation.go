@@ -0,0 +1,123 @@
+package validation
+
+import (
+	"ecommerce-inventory/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes why one field of a payload is invalid. Code is
+// machine readable, Message is meant for people.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors lists every invalid field of a payload
+type Errors []FieldError
+
+func (errs Errors) Error() string {
+	fields := make([]string, len(errs))
+	for i, field := range errs {
+		fields[i] = field.Field + ": " + field.Message
+	}
+	return "validation failed: " + strings.Join(fields, "; ")
+}
+
+func (errs Errors) Unwrap() error {
+	return model.ErrValidation
+}
+
+// Err returns errs as an error, or nil if there are none
+func (errs Errors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	// Report fields by their JSON names, or by their lowercased Go names for
+	// fields that are never serialized
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return strings.ToLower(field.Name)
+		}
+		return name
+	})
+	return validate
+}
+
+// Struct checks v against the rules in its validate tags and returns the
+// fields that break them, or nil if v is valid
+func Struct(v any) Errors {
+	err := validate.Struct(v)
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		if err != nil {
+			// Only happens when v is not a struct, which is a bug
+			panic(err)
+		}
+		return nil
+	}
+
+	errs := make(Errors, len(fieldErrs))
+	for i, fieldErr := range fieldErrs {
+		// The namespace starts with the struct name, which clients do not know
+		_, field, _ := strings.Cut(fieldErr.Namespace(), ".")
+		code, message := describe(fieldErr)
+		errs[i] = FieldError{Field: field, Code: code, Message: message}
+	}
+	return errs
+}
+
+// describe turns a failed validator tag into an error code and message
+func describe(fieldErr validator.FieldError) (string, string) {
+	param := fieldErr.Param()
+	text := fieldErr.Kind() == reflect.String
+	switch fieldErr.Tag() {
+	case "required":
+		return "required", "is required"
+	case "min", "gte":
+		if text {
+			return "too_short", fmt.Sprintf("must be at least %s characters long", param)
+		}
+		return "too_small", "must be at least " + param
+	case "max", "lte":
+		if text {
+			return "too_long", fmt.Sprintf("must be at most %s characters long", param)
+		}
+		return "too_large", "must be at most " + param
+	case "gt":
+		return "too_small", "must be greater than " + param
+	case "lt":
+		return "too_large", "must be less than " + param
+	case "oneof":
+		return "not_allowed", "must be one of " + strings.ReplaceAll(param, " ", ", ")
+	}
+	return fieldErr.Tag(), "is invalid"
+}
+
+// TypeError reports a JSON value of the wrong type for its field, or returns
+// nil if err is not a *json.UnmarshalTypeError
+func TypeError(err error) Errors {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return nil
+	}
+	expected := "number"
+	if typeErr.Type.Kind() == reflect.String {
+		expected = "string"
+	}
+	return Errors{{Field: typeErr.Field, Code: "invalid_type", Message: "must be a " + expected}}
+}